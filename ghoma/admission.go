@@ -0,0 +1,152 @@
+package ghoma
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+var ErrDeviceNotAllowed = errors.New("device not allowed")
+
+// Reasons a connection is rejected for, used as metric label.
+const (
+	rejectNetwork          = "network"
+	rejectRate             = "rate"
+	rejectConnections      = "connections"
+	rejectConnectionsPerIP = "connections_per_ip"
+	rejectDevice           = "device"
+	rejectHandshakeTimeout = "handshake_timeout"
+)
+
+// admission decides whether an accepted connection is served, and tracks
+// the connections being served to enforce the caps.
+type admission struct {
+	options *ServerOptions
+	limiter *rate.Limiter
+	devices map[string]bool
+
+	mu    sync.Mutex
+	total int
+	perIP map[netip.Addr]int
+}
+
+func newAdmission(options *ServerOptions) *admission {
+	a := &admission{
+		options: options,
+		perIP:   make(map[netip.Addr]int),
+	}
+	if options.AcceptRate > 0 {
+		a.limiter = rate.NewLimiter(rate.Limit(options.AcceptRate), max(options.AcceptBurst, 1))
+	}
+	for _, id := range options.AllowedDevices {
+		if id == "" {
+			continue
+		}
+		if a.devices == nil {
+			a.devices = make(map[string]bool, len(options.AllowedDevices))
+		}
+		a.devices[strings.ToLower(id)] = true
+	}
+	return a
+}
+
+// admit returns the reason the connection from addr is rejected, or a
+// release func to call once the connection is closed.
+func (a *admission) admit(addr net.Addr) (release func(), reason string) {
+	ip := addrIP(addr)
+	if !a.allowed(ip) {
+		return nil, rejectNetwork
+	}
+	if a.limiter != nil && !a.limiter.Allow() {
+		return nil, rejectRate
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.options.MaxConnections > 0 && a.total >= a.options.MaxConnections {
+		return nil, rejectConnections
+	}
+	if a.options.MaxConnectionsPerIP > 0 && a.perIP[ip] >= a.options.MaxConnectionsPerIP {
+		return nil, rejectConnectionsPerIP
+	}
+	a.total++
+	a.perIP[ip]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			a.total--
+			if a.perIP[ip]--; a.perIP[ip] <= 0 {
+				delete(a.perIP, ip)
+			}
+		})
+	}, ""
+}
+
+// allowed checks the IP against the networks, denied networks win over
+// allowed ones and every IP is allowed when no allowed network is set.
+func (a *admission) allowed(ip netip.Addr) bool {
+	for _, n := range a.options.DeniedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.options.AllowedNetworks) == 0 {
+		return true
+	}
+	for _, n := range a.options.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedDevice checks the ID reported in the INIT1 reply against the
+// allowlist, every device is allowed when it is empty.
+func (a *admission) allowedDevice(id string) error {
+	if a.devices == nil || a.devices[id] {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrDeviceNotAllowed, id)
+}
+
+func addrIP(addr net.Addr) netip.Addr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, _ := netip.AddrFromSlice(tcp.IP)
+		return ip.Unmap()
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// ParseNetworks parses CIDR prefixes, a single IP address being a prefix
+// matching only itself.
+func ParseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, n := range networks {
+		if n == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(n)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(n)
+			if ipErr != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", n, err)
+			}
+			prefix = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,107 @@
+package ghoma
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestAdmission_Admit(t *testing.T) {
+	allowed, err := ParseNetworks([]string{"192.168.1.0/24", "10.0.0.7"})
+	require.NoError(t, err)
+	denied, err := ParseNetworks([]string{"192.168.1.66"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		options  ServerOptions
+		clients  []string
+		expected []string
+	}{
+		{
+			name:     "any network by default",
+			clients:  []string{"192.168.1.10", "172.16.0.1"},
+			expected: []string{"", ""},
+		},
+		{
+			name:     "allowed and denied networks",
+			options:  ServerOptions{AllowedNetworks: allowed, DeniedNetworks: denied},
+			clients:  []string{"192.168.1.10", "10.0.0.7", "10.0.0.8", "192.168.1.66", "::ffff:192.168.1.11"},
+			expected: []string{"", "", rejectNetwork, rejectNetwork, ""},
+		},
+		{
+			name:     "max connections",
+			options:  ServerOptions{MaxConnections: 2},
+			clients:  []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"},
+			expected: []string{"", "", rejectConnections},
+		},
+		{
+			name:     "max connections per ip",
+			options:  ServerOptions{MaxConnectionsPerIP: 1},
+			clients:  []string{"192.168.1.10", "192.168.1.11", "192.168.1.10"},
+			expected: []string{"", "", rejectConnectionsPerIP},
+		},
+		{
+			name:     "accept rate",
+			options:  ServerOptions{AcceptRate: 0.001, AcceptBurst: 2},
+			clients:  []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"},
+			expected: []string{"", "", rejectRate},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdmission(&tt.options)
+			var reasons []string
+			for _, client := range tt.clients {
+				_, reason := a.admit(tcpAddr(client))
+				reasons = append(reasons, reason)
+			}
+			require.Equal(t, tt.expected, reasons)
+		})
+	}
+}
+
+func TestAdmission_Release(t *testing.T) {
+	a := newAdmission(&ServerOptions{MaxConnections: 1, MaxConnectionsPerIP: 1})
+
+	release, _ := a.admit(tcpAddr("192.168.1.10"))
+	require.NotNil(t, release)
+	_, reason := a.admit(tcpAddr("192.168.1.10"))
+	require.Equal(t, rejectConnections, reason)
+
+	release()
+	release()
+	require.Equal(t, 0, a.total)
+	require.Empty(t, a.perIP)
+
+	release, _ = a.admit(tcpAddr("192.168.1.10"))
+	require.NotNil(t, release)
+}
+
+func TestAdmission_AllowedDevice(t *testing.T) {
+	require.NoError(t, newAdmission(&ServerOptions{}).allowedDevice("d78a1c"))
+	require.NoError(t, newAdmission(&ServerOptions{AllowedDevices: []string{""}}).allowedDevice("d78a1c"))
+
+	a := newAdmission(&ServerOptions{AllowedDevices: []string{"D78A1C"}})
+	require.NoError(t, a.allowedDevice("d78a1c"))
+	require.ErrorIs(t, a.allowedDevice("0a0b0c"), ErrDeviceNotAllowed)
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"192.168.1.12/24", "10.0.0.7", "", "fd00::/8"})
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("10.0.0.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}, networks)
+
+	_, err = ParseNetworks([]string{"192.168.1"})
+	require.Error(t, err)
+}
@@ -0,0 +1,551 @@
+package ghoma
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/protocol"
+	"github.com/eliecharra/ghoma/trace"
+)
+
+const queueSize = 16
+
+var (
+	ErrDeviceOffline  = errors.New("device offline")
+	ErrCommandTimeout = errors.New("command timed out")
+	ErrHandshake      = errors.New("unexpected handshake message")
+	ErrStateUnknown   = errors.New("switch state not reported yet")
+)
+
+type Device struct {
+	logger  *zap.Logger
+	options *ServerOptions
+	metrics *serverMetrics
+
+	ID              string
+	FirmwareVersion string
+	conn            net.Conn
+	reader          *bufio.Reader
+	connectedAt     time.Time
+	triggerCode     []byte
+	shortMac        []byte
+
+	lastFrame     atomic.Int64
+	framesRead    atomic.Uint64
+	framesWritten atomic.Uint64
+	unknownFrames atomic.Uint64
+	invalidFrames atomic.Uint64
+
+	mu        sync.Mutex
+	queue     chan *command
+	closed    chan struct{}
+	closeOnce sync.Once
+	pending   *command
+	// inflight lets one command wait for its ack at a time, as acks do not
+	// tell which command they answer
+	inflight chan struct{}
+
+	tracer    *tracer
+	handshake []trace.Record
+
+	// Last state reported by the plug, guarded by mu
+	deviceType protocol.DeviceType
+	outlets    map[int]*outletState
+	updatedAt  time.Time
+	// previous is the switch state of every outlet at the end of the
+	// previous session, set before the session starts.
+	previous map[int]bool
+}
+
+// command is a message waiting in the device outbound queue, or the command
+// waiting for an ack when it has an ack func.
+type command struct {
+	ctx   context.Context
+	msg   protocol.Message
+	ack   func(*protocol.Message) bool
+	acked chan struct{}
+	once  sync.Once
+	done  chan error
+
+	queued time.Time
+	// async commands are not waited for, their errors are logged.
+	async bool
+	// barrier commands are not sent, they are done once every command
+	// queued before them is.
+	barrier bool
+}
+
+func newDevice(logger *zap.Logger, options *ServerOptions, metrics *serverMetrics, c net.Conn) *Device {
+	conn := metrics.count(c)
+	return &Device{
+		logger:      logger,
+		options:     options,
+		metrics:     metrics,
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		connectedAt: time.Now(),
+		queue:       make(chan *command, queueSize),
+		closed:      make(chan struct{}),
+		inflight:    make(chan struct{}, 1),
+
+		outlets: make(map[int]*outletState),
+	}
+}
+
+func (d *Device) RemoteAddr() string {
+	return d.conn.RemoteAddr().String()
+}
+
+// Switch turns the plug on or off and waits for the plug to report the
+// requested state. Multi-outlet devices only switch their first outlet.
+func (d *Device) Switch(ctx context.Context, on bool) error {
+	return d.SwitchOutlet(ctx, 1, on)
+}
+
+// SwitchOutlet turns a single outlet on or off, outlets are numbered from 1.
+func (d *Device) SwitchOutlet(ctx context.Context, outlet int, on bool) error {
+	msg := *protocol.MustParse(protocol.SwitchOutlet(d.triggerCode, d.shortMac, outlet, on))
+	return d.exec(ctx, msg, func(msg *protocol.Message) bool {
+		return msg.Command == protocol.CmdStatus && msg.Status.Outlet == outlet &&
+			msg.Status.Switch != nil && *msg.Status.Switch == on
+	})
+}
+
+// On turns the plug on, see Switch.
+func (d *Device) On(ctx context.Context) error {
+	return d.Switch(ctx, true)
+}
+
+// Off turns the plug off, see Switch.
+func (d *Device) Off(ctx context.Context) error {
+	return d.Switch(ctx, false)
+}
+
+// Toggle switches the plug to the opposite of the state it last reported
+// and returns the new state, it fails with ErrStateUnknown until the plug
+// reported its state.
+func (d *Device) Toggle(ctx context.Context) (bool, error) {
+	return d.ToggleOutlet(ctx, 1)
+}
+
+func (d *Device) ToggleOutlet(ctx context.Context, outlet int) (bool, error) {
+	d.mu.Lock()
+	var on *bool
+	if o, exist := d.outlets[outlet]; exist {
+		on = o.on
+	}
+	d.mu.Unlock()
+	if on == nil {
+		return false, ErrStateUnknown
+	}
+	return !*on, d.SwitchOutlet(ctx, outlet, !*on)
+}
+
+// Outlet is the last state reported for an outlet of a device.
+type Outlet struct {
+	Outlet int `json:"outlet"`
+	// On is nil until the device reported the outlet switch state.
+	On *bool `json:"on,omitempty"`
+	// Measurements holds the last reading of every energy kind.
+	Measurements map[string]protocol.Measurement `json:"measurements"`
+}
+
+// Status is the last state reported by a device, On and Measurements are
+// the ones of its first outlet.
+type Status struct {
+	ID           string                          `json:"id"`
+	Online       bool                            `json:"online"`
+	Type         string                          `json:"type,omitempty"`
+	On           *bool                           `json:"on,omitempty"`
+	Measurements map[string]protocol.Measurement `json:"measurements"`
+	Outlets      []Outlet                        `json:"outlets"`
+	UpdatedAt    time.Time                       `json:"updated_at"`
+}
+
+type outletState struct {
+	on           *bool
+	measurements map[string]protocol.Measurement
+}
+
+// Status returns the last state reported by the plug.
+func (d *Device) Status() Status {
+	status := Status{ID: d.ID, Online: true, Outlets: make([]Outlet, 0, 1)}
+	select {
+	case <-d.closed:
+		status.Online = false
+	default:
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.deviceType != 0 {
+		status.Type = d.deviceType.String()
+	}
+	for n, o := range d.outlets {
+		outlet := Outlet{Outlet: n, Measurements: make(map[string]protocol.Measurement, len(o.measurements))}
+		if o.on != nil {
+			on := *o.on
+			outlet.On = &on
+		}
+		for kind, m := range o.measurements {
+			outlet.Measurements[kind] = m
+		}
+		status.Outlets = append(status.Outlets, outlet)
+	}
+	sort.Slice(status.Outlets, func(i, j int) bool {
+		return status.Outlets[i].Outlet < status.Outlets[j].Outlet
+	})
+	status.Measurements = map[string]protocol.Measurement{}
+	if len(status.Outlets) > 0 && status.Outlets[0].Outlet == 1 {
+		status.On = status.Outlets[0].On
+		status.Measurements = status.Outlets[0].Measurements
+	}
+	status.UpdatedAt = d.updatedAt
+	return status
+}
+
+// update records the state carried by a status message.
+func (d *Device) update(msg *protocol.Message) {
+	if msg.Status == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if msg.Status.Type != 0 {
+		d.deviceType = msg.Status.Type
+	}
+	o, exist := d.outlets[msg.Status.Outlet]
+	if !exist {
+		o = &outletState{measurements: make(map[string]protocol.Measurement)}
+		d.outlets[msg.Status.Outlet] = o
+	}
+	if msg.Status.Switch != nil {
+		on := *msg.Status.Switch
+		if last, known := d.lastState(msg.Status.Outlet); known && last != on {
+			d.metrics.switchChanges.WithLabelValues(d.ID, string(msg.Status.Source)).Inc()
+		}
+		o.on = &on
+	}
+	if msg.Status.Energy != nil {
+		m := msg.Status.Energy.Measurement()
+		o.measurements[m.Kind] = m
+	}
+	d.updatedAt = time.Now()
+}
+
+// powerOnWindow is how long after connecting a plug reports the state it
+// came up with.
+const powerOnWindow = 10 * time.Second
+
+// attribute refines the source of a switch report. Plugs redirected to the
+// server only take remote commands from it, so a remote change no switch
+// command waits for comes from a schedule stored on the plug. The first
+// state reported right after connecting is the one the plug came up with,
+// unless a state is known from a previous session. Neither is decoded from
+// the payload: reports repeating the last state and button presses keep
+// the source they were sent with.
+func (d *Device) attribute(msg *protocol.Message) {
+	if msg.Status == nil || msg.Status.Switch == nil || msg.Status.Source == protocol.SourceButton {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	last, known := d.lastState(msg.Status.Outlet)
+	switch {
+	case !known && time.Since(d.connectedAt) < powerOnWindow:
+		msg.Status.Source = protocol.SourcePowerOn
+	case known && last != *msg.Status.Switch && msg.Status.Source == protocol.SourceRemote &&
+		(d.pending == nil || d.pending.msg.Command != protocol.CmdSwitch):
+		msg.Status.Source = protocol.SourceTimer
+	}
+}
+
+// lastState returns the last switch state of an outlet, reported in this
+// session or else in the previous one. mu must be held.
+func (d *Device) lastState(outlet int) (on bool, known bool) {
+	if o, exist := d.outlets[outlet]; exist && o.on != nil {
+		return *o.on, true
+	}
+	on, known = d.previous[outlet]
+	return on, known
+}
+
+// switches returns the last switch state of every outlet, see lastState.
+func (d *Device) switches() map[int]bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switches := make(map[int]bool, len(d.previous)+len(d.outlets))
+	for outlet, on := range d.previous {
+		switches[outlet] = on
+	}
+	for outlet, o := range d.outlets {
+		if o.on != nil {
+			switches[outlet] = *o.on
+		}
+	}
+	return switches
+}
+
+// post queues a message without waiting for it to be sent, the message is
+// dropped when the queue is full so the read loop never blocks on it.
+func (d *Device) post(msg protocol.Message) {
+	cmd := &command{ctx: context.Background(), msg: msg, done: make(chan error, 1), queued: time.Now(), async: true}
+	select {
+	case d.queue <- cmd:
+	case <-d.closed:
+	default:
+		d.logger.Warn("outbound queue full, dropping message", zap.Stringer("command", msg.Command))
+	}
+}
+
+// exec sends a message until it is acknowledged, it is sent again every
+// CommandTimeout up to CommandRetries times. The writer goroutine is not
+// held while waiting, so heartbeat replies keep flowing.
+func (d *Device) exec(ctx context.Context, msg protocol.Message, ack func(*protocol.Message) bool) error {
+	err := d.execute(ctx, msg, ack)
+	d.metrics.commands.WithLabelValues(resultLabel(err)).Inc()
+	return err
+}
+
+func (d *Device) execute(ctx context.Context, msg protocol.Message, ack func(*protocol.Message) bool) error {
+	select {
+	case d.inflight <- struct{}{}:
+	case <-d.closed:
+		return ErrDeviceOffline
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-d.inflight }()
+
+	cmd := &command{ctx: ctx, msg: msg, ack: ack, acked: make(chan struct{})}
+	defer d.clearPending()
+	for attempt := 0; attempt <= d.options.CommandRetries; attempt++ {
+		if attempt > 0 {
+			d.logger.Debug("retrying command", zap.Stringer("command", msg.Command), zap.Int("attempt", attempt))
+		}
+
+		// The ack may have come in as the previous attempt timed out
+		if !d.setPending(cmd) {
+			return nil
+		}
+		if err := d.enqueue(ctx, msg); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(d.options.CommandTimeout)
+		select {
+		case <-cmd.acked:
+			timer.Stop()
+			return nil
+		case <-d.closed:
+			timer.Stop()
+			return ErrDeviceOffline
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return ErrCommandTimeout
+}
+
+// enqueue queues a message and waits until it is written.
+func (d *Device) enqueue(ctx context.Context, msg protocol.Message) error {
+	cmd := &command{ctx: ctx, msg: msg, done: make(chan error, 1)}
+	select {
+	case d.queue <- cmd:
+	case <-d.closed:
+		return ErrDeviceOffline
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-cmd.done:
+		return err
+	case <-d.closed:
+		return ErrDeviceOffline
+	}
+}
+
+// drain waits until every message queued so far has been sent.
+func (d *Device) drain(ctx context.Context) error {
+	cmd := &command{ctx: ctx, done: make(chan error, 1), barrier: true}
+	select {
+	case d.queue <- cmd:
+	case <-d.closed:
+		return ErrDeviceOffline
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-cmd.done:
+		return err
+	case <-d.closed:
+		return ErrDeviceOffline
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acknowledge resolves the pending command if msg is the answer it waits for.
+func (d *Device) acknowledge(msg *protocol.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending != nil && d.pending.ack(msg) {
+		d.pending.once.Do(func() { close(d.pending.acked) })
+		d.pending = nil
+	}
+}
+
+// run writes every outbound message in order until the device is closed.
+func (d *Device) run() {
+	for {
+		select {
+		case <-d.closed:
+			for {
+				select {
+				case cmd := <-d.queue:
+					cmd.done <- ErrDeviceOffline
+				default:
+					return
+				}
+			}
+		case cmd := <-d.queue:
+			if cmd.barrier {
+				cmd.done <- nil
+				continue
+			}
+			err := d.send(cmd)
+			if err == nil && cmd.msg.Command == protocol.CmdHeartBeatReply {
+				d.metrics.heartbeatLatency.Observe(time.Since(cmd.queued).Seconds())
+			}
+			if err != nil && cmd.async {
+				d.logger.Error("unable to send message", zap.Stringer("command", cmd.msg.Command), zap.Error(err))
+			}
+			cmd.done <- err
+		}
+	}
+}
+
+func (d *Device) send(cmd *command) error {
+	if err := cmd.ctx.Err(); err != nil {
+		return err
+	}
+	if err := d.write(cmd.msg); err != nil {
+		return errors.Join(ErrDeviceOffline, err)
+	}
+	return nil
+}
+
+// setPending makes cmd the command waiting for an ack, unless it has been
+// acked already.
+func (d *Device) setPending(cmd *command) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-cmd.acked:
+		return false
+	default:
+	}
+	d.pending = cmd
+	return true
+}
+
+func (d *Device) clearPending() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = nil
+}
+
+func (d *Device) close() {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+	})
+}
+
+func (d *Device) read() (*protocol.Message, error) {
+	frame, err := protocol.ReadFrame(d.reader)
+	if len(frame) > 0 {
+		d.record(trace.In, frame, err)
+	}
+	var msg *protocol.Message
+	if err == nil {
+		msg, err = protocol.Parse(frame[4 : len(frame)-3])
+	}
+	switch {
+	case errors.Is(err, protocol.ErrCmdUnknown):
+		d.unknownFrames.Add(1)
+		d.lastFrame.Store(time.Now().UnixNano())
+	case errors.Is(err, protocol.ErrInvalidFrame):
+		d.invalidFrames.Add(1)
+	case err == nil:
+		d.framesRead.Add(1)
+		d.lastFrame.Store(time.Now().UnixNano())
+	}
+	if err != nil {
+		return msg, err
+	}
+	d.logger.Debug("read", zap.Any("msg", msg))
+	return msg, nil
+}
+
+func (d *Device) write(msg protocol.Message) error {
+	frame := msg.ToBytes()
+	if _, err := d.conn.Write(frame); err != nil {
+		d.record(trace.Out, frame, err)
+		return err
+	}
+	d.record(trace.Out, frame, nil)
+	d.framesWritten.Add(1)
+	d.logger.Debug("write", zap.Any("msg", msg))
+	return nil
+}
+
+// DeviceState is the internal state of a device connection, for debugging.
+type DeviceState struct {
+	ID              string     `json:"id"`
+	FirmwareVersion string     `json:"firmware_version"`
+	RemoteAddress   string     `json:"remote_address"`
+	ConnectedAt     time.Time  `json:"connected_at"`
+	LastFrame       *time.Time `json:"last_frame,omitempty"`
+	QueueDepth      int        `json:"queue_depth"`
+	PendingCommand  string     `json:"pending_command,omitempty"`
+	FramesRead      uint64     `json:"frames_read"`
+	FramesWritten   uint64     `json:"frames_written"`
+	UnknownFrames   uint64     `json:"unknown_frames"`
+	InvalidFrames   uint64     `json:"invalid_frames"`
+}
+
+func (d *Device) State() DeviceState {
+	state := DeviceState{
+		ID:              d.ID,
+		FirmwareVersion: d.FirmwareVersion,
+		RemoteAddress:   d.RemoteAddr(),
+		ConnectedAt:     d.connectedAt,
+		QueueDepth:      len(d.queue),
+		FramesRead:      d.framesRead.Load(),
+		FramesWritten:   d.framesWritten.Load(),
+		UnknownFrames:   d.unknownFrames.Load(),
+		InvalidFrames:   d.invalidFrames.Load(),
+	}
+	if nanos := d.lastFrame.Load(); nanos != 0 {
+		t := time.Unix(0, nanos)
+		state.LastFrame = &t
+	}
+	d.mu.Lock()
+	if d.pending != nil {
+		state.PendingCommand = d.pending.msg.Command.String()
+	}
+	d.mu.Unlock()
+	return state
+}
@@ -0,0 +1,328 @@
+package ghoma
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+func newTestDevice(t *testing.T, options ServerOptions) (*Device, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	dev := newDevice(zap.NewNop(), &options, newServerMetrics(), server)
+	dev.triggerCode = []byte{0x32, 0x23}
+	dev.shortMac = []byte{0xd7, 0x8a, 0x1c}
+	go dev.run()
+	t.Cleanup(dev.close)
+
+	return dev, client
+}
+
+func switchStatus(on bool) *protocol.Message {
+	return outletStatus(1, on)
+}
+
+func outletStatus(outlet int, on bool) *protocol.Message {
+	state := on
+	return &protocol.Message{Command: protocol.CmdStatus, Status: &protocol.Status{Switch: &state, Outlet: outlet}}
+}
+
+func TestDevice_Switch(t *testing.T) {
+	tests := []struct {
+		name          string
+		outlet        int
+		answer        *protocol.Message
+		expectedError error
+		expectedSends int
+	}{
+		{
+			name:          "acked",
+			answer:        switchStatus(true),
+			expectedSends: 1,
+		},
+		{
+			name:          "wrong state is not an ack",
+			answer:        switchStatus(false),
+			expectedError: ErrCommandTimeout,
+			expectedSends: 3,
+		},
+		{
+			name:          "outlet acked",
+			outlet:        2,
+			answer:        outletStatus(2, true),
+			expectedSends: 1,
+		},
+		{
+			name:          "other outlet is not an ack",
+			outlet:        2,
+			answer:        switchStatus(true),
+			expectedError: ErrCommandTimeout,
+			expectedSends: 3,
+		},
+		{
+			name:          "no answer",
+			expectedError: ErrCommandTimeout,
+			expectedSends: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, plug := newTestDevice(t, ServerOptions{CommandTimeout: 50 * time.Millisecond, CommandRetries: 2})
+
+			sends := atomic.Int32{}
+			go func() {
+				for {
+					msg, err := protocol.ReadMessage(plug)
+					if err != nil {
+						return
+					}
+					assert.Equal(t, protocol.CmdSwitch, msg.Command)
+					if tt.outlet != 0 {
+						assert.Equal(t, byte(tt.outlet), msg.Payload[18])
+					}
+					sends.Add(1)
+					if tt.answer != nil {
+						dev.acknowledge(tt.answer)
+					}
+				}
+			}()
+
+			var err error
+			if tt.outlet != 0 {
+				err = dev.SwitchOutlet(context.Background(), tt.outlet, true)
+			} else {
+				err = dev.Switch(context.Background(), true)
+			}
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.expectedSends, int(sends.Load()))
+		})
+	}
+}
+
+func TestDevice_Switch_AckAtTimeout(t *testing.T) {
+	timeout := time.Millisecond
+	dev, plug := newTestDevice(t, ServerOptions{CommandTimeout: timeout, CommandRetries: 2})
+	go func() {
+		for {
+			if _, err := protocol.ReadMessage(plug); err != nil {
+				return
+			}
+			time.AfterFunc(timeout, func() { dev.acknowledge(switchStatus(true)) })
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		err := dev.Switch(context.Background(), true)
+		if err != nil {
+			require.ErrorIs(t, err, ErrCommandTimeout)
+		}
+	}
+
+	// The ack resolved the command as its attempt timed out: it is not
+	// pending again and a second matching report does not resolve it twice
+	time.Sleep(10 * timeout)
+	cmd := &command{ack: func(*protocol.Message) bool { return true }, acked: make(chan struct{})}
+	require.True(t, dev.setPending(cmd))
+	dev.acknowledge(switchStatus(true))
+	require.False(t, dev.setPending(cmd))
+	dev.mu.Lock()
+	dev.pending = cmd
+	dev.mu.Unlock()
+	require.NotPanics(t, func() { dev.acknowledge(switchStatus(true)) })
+}
+
+func TestDevice_Switch_HeartbeatWhileWaiting(t *testing.T) {
+	dev, plug := newTestDevice(t, ServerOptions{CommandTimeout: time.Second})
+
+	done := make(chan error, 1)
+	go func() { done <- dev.Switch(context.Background(), true) }()
+	msg, err := protocol.ReadMessage(plug)
+	require.NoError(t, err)
+	require.Equal(t, protocol.CmdSwitch, msg.Command)
+
+	// The switch waits for its ack, heartbeat replies are not held behind it
+	for i := 0; i < 2*queueSize; i++ {
+		dev.post(*protocol.MustParse(protocol.HeartBeatReply))
+		_ = plug.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		msg, err = protocol.ReadMessage(plug)
+		require.NoError(t, err)
+		require.Equal(t, protocol.CmdHeartBeatReply, msg.Command)
+	}
+
+	dev.acknowledge(switchStatus(true))
+	require.NoError(t, <-done)
+}
+
+func TestDevice_Switch_Offline(t *testing.T) {
+	dev, _ := newTestDevice(t, ServerOptions{CommandTimeout: time.Second})
+	dev.close()
+
+	err := dev.Switch(context.Background(), true)
+	require.ErrorIs(t, err, ErrDeviceOffline)
+}
+
+func TestDevice_State(t *testing.T) {
+	dev, client := newTestDevice(t, ServerOptions{})
+	dev.ID = "d78a1c"
+
+	go func() {
+		frames := [][]byte{
+			protocol.Message{Payload: []byte{0x04}}.ToBytes(),
+			protocol.Message{Payload: []byte{0xee, 0x01}}.ToBytes(),
+			{0x5a, 0xa5, 0x00, 0x01, 0x04, 0x00, 0x5b, 0xb5},
+		}
+		for _, frame := range frames {
+			_, _ = client.Write(frame)
+		}
+	}()
+	_, err := dev.read()
+	require.NoError(t, err)
+	_, err = dev.read()
+	require.ErrorIs(t, err, protocol.ErrCmdUnknown)
+	_, err = dev.read()
+	require.ErrorIs(t, err, protocol.ErrInvalidFrame)
+
+	state := dev.State()
+	require.Equal(t, "d78a1c", state.ID)
+	require.Equal(t, uint64(1), state.FramesRead)
+	require.Equal(t, uint64(1), state.UnknownFrames)
+	require.Equal(t, uint64(1), state.InvalidFrames)
+	require.NotNil(t, state.LastFrame)
+	require.Equal(t, 0, state.QueueDepth)
+}
+
+func TestDevice_Status(t *testing.T) {
+	dev, plug := newTestDevice(t, ServerOptions{CommandTimeout: time.Second})
+	dev.ID = "d78a1c"
+
+	status := dev.Status()
+	require.True(t, status.Online)
+	require.Nil(t, status.On)
+	require.Empty(t, status.Measurements)
+	_, err := dev.Toggle(context.Background())
+	require.ErrorIs(t, err, ErrStateUnknown)
+
+	payload, err := hex.DecodeString("90010ae03223d78a1cfffe01813900000101000001f4")
+	require.NoError(t, err)
+	power, err := protocol.Parse(payload)
+	require.NoError(t, err)
+	dev.update(power)
+	dev.update(switchStatus(true))
+
+	status = dev.Status()
+	require.NotNil(t, status.On)
+	require.True(t, *status.On)
+	require.Equal(t, protocol.Measurement{Kind: "POWER", Value: 5, Unit: "W", Verified: true}, status.Measurements["POWER"])
+	require.False(t, status.UpdatedAt.IsZero())
+	require.Equal(t, "plug", status.Type)
+
+	dev.update(outletStatus(2, false))
+	status = dev.Status()
+	require.Len(t, status.Outlets, 2)
+	require.Equal(t, 1, status.Outlets[0].Outlet)
+	require.Equal(t, 2, status.Outlets[1].Outlet)
+	require.False(t, *status.Outlets[1].On)
+	require.Empty(t, status.Outlets[1].Measurements)
+	require.True(t, *status.On)
+
+	go func() {
+		msg, err := protocol.ReadMessage(plug)
+		if err != nil {
+			return
+		}
+		assert.Equal(t, protocol.CmdSwitch, msg.Command)
+		dev.acknowledge(switchStatus(false))
+	}()
+	on, err := dev.Toggle(context.Background())
+	require.NoError(t, err)
+	require.False(t, on)
+
+	dev.close()
+	require.False(t, dev.Status().Online)
+}
+
+func TestDevice_Attribute(t *testing.T) {
+	sourced := func(outlet int, on bool, source protocol.SwitchSource) *protocol.Message {
+		msg := outletStatus(outlet, on)
+		msg.Status.Source = source
+		return msg
+	}
+	switchCmd := &command{msg: *protocol.MustParse(protocol.Switch([]byte{0x32, 0x23}, []byte{0xd7, 0x8a, 0x1c}, true))}
+
+	tests := []struct {
+		name      string
+		connected time.Duration
+		known     bool
+		previous  map[int]bool
+		pending   *command
+		msg       *protocol.Message
+		want      protocol.SwitchSource
+	}{
+		{name: "state on connect", connected: time.Second, msg: sourced(1, true, protocol.SourceRemote), want: protocol.SourcePowerOn},
+		{name: "button on connect", connected: time.Second, msg: sourced(1, true, protocol.SourceButton), want: protocol.SourceButton},
+		{name: "first state long after connect", connected: time.Minute, msg: sourced(1, true, protocol.SourceButton), want: protocol.SourceButton},
+		{name: "button", known: true, msg: sourced(1, false, protocol.SourceButton), want: protocol.SourceButton},
+		{name: "switch command", known: true, pending: switchCmd, msg: sourced(1, true, protocol.SourceRemote), want: protocol.SourceRemote},
+		{name: "remote without command", known: true, msg: sourced(1, true, protocol.SourceRemote), want: protocol.SourceTimer},
+		{name: "button without command", known: true, msg: sourced(1, true, protocol.SourceButton), want: protocol.SourceButton},
+		{name: "other outlet on connect", known: true, msg: sourced(2, true, protocol.SourceRemote), want: protocol.SourcePowerOn},
+		{name: "remote without change", known: true, msg: sourced(1, false, protocol.SourceRemote), want: protocol.SourceRemote},
+		{name: "state on reconnect", connected: time.Second, previous: map[int]bool{1: true}, msg: sourced(1, true, protocol.SourceRemote), want: protocol.SourceRemote},
+		{name: "changed since previous session", connected: time.Second, previous: map[int]bool{1: false}, msg: sourced(1, true, protocol.SourceRemote), want: protocol.SourceTimer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, _ := newTestDevice(t, ServerOptions{})
+			dev.connectedAt = time.Now().Add(-tt.connected)
+			dev.previous = tt.previous
+			if tt.known {
+				dev.update(switchStatus(false))
+			}
+			dev.pending = tt.pending
+
+			dev.attribute(tt.msg)
+			require.Equal(t, tt.want, tt.msg.Status.Source)
+		})
+	}
+}
+
+func TestDevice_SwitchChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous map[int]bool
+		reports  []bool
+		want     float64
+	}{
+		{name: "first state", reports: []bool{true, true, false}, want: 1},
+		{name: "same as previous session", previous: map[int]bool{1: true}, reports: []bool{true}, want: 0},
+		{name: "changed since previous session", previous: map[int]bool{1: false}, reports: []bool{true, true, false}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, _ := newTestDevice(t, ServerOptions{})
+			dev.ID = "d78a1c"
+			dev.previous = tt.previous
+
+			for _, on := range tt.reports {
+				msg := switchStatus(on)
+				msg.Status.Source = protocol.SourceButton
+				dev.update(msg)
+			}
+			require.Equal(t, tt.want, testutil.ToFloat64(dev.metrics.switchChanges.WithLabelValues("d78a1c", "button")))
+		})
+	}
+}
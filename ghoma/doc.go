@@ -0,0 +1,19 @@
+// Package ghoma implements the server G-Homa plugs connect to once pointed
+// away from the vendor cloud, it registers the plugs, answers their
+// heartbeats and switches them on and off.
+//
+//	server := ghoma.NewServer(
+//		ghoma.WithListenAddr(":4196"),
+//		ghoma.WithEventHandler(ghoma.Handlers{
+//			Measurement: func(e ghoma.MeasurementEvent) {
+//				fmt.Println(e.Device, e.Kind, e.Value, e.Unit)
+//			},
+//		}),
+//	)
+//	if err := server.Start(ctx); err != nil {
+//		return err
+//	}
+//	if dev, online := server.Device("d78a1c"); online {
+//		err = dev.On(ctx)
+//	}
+package ghoma
@@ -0,0 +1,117 @@
+package ghoma
+
+import (
+	"time"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+type EventKind string
+
+const (
+	EventConnected    EventKind = "connected"
+	EventDisconnected EventKind = "disconnected"
+	EventMessage      EventKind = "message"
+)
+
+type Event struct {
+	Kind    EventKind
+	Device  string
+	Time    time.Time
+	Message *protocol.Message
+
+	device *Device
+}
+
+// EventHandler receives every event emitted by the server. HandleEvent is
+// called from the device goroutine and must not block.
+type EventHandler interface {
+	HandleEvent(Event)
+}
+
+// AddEventHandler registers h to receive server events, it must be called
+// before Start.
+func (s *Server) AddEventHandler(h EventHandler) {
+	s.eventHandlers = append(s.eventHandlers, h)
+}
+
+func (s *Server) emit(kind EventKind, dev *Device, msg *protocol.Message) {
+	e := Event{
+		Kind:    kind,
+		Device:  dev.ID,
+		Time:    time.Now(),
+		Message: msg,
+		device:  dev,
+	}
+	for _, h := range s.eventHandlers {
+		h.HandleEvent(e)
+	}
+}
+
+type ConnectedEvent struct {
+	Device          string
+	Time            time.Time
+	FirmwareVersion string
+	RemoteAddress   string
+}
+
+type DisconnectedEvent struct {
+	Device string
+	Time   time.Time
+}
+
+// SwitchEvent is sent whenever a plug reports its switch state, not only
+// when it changed.
+type SwitchEvent struct {
+	Device string
+	Outlet int
+	Time   time.Time
+	On     bool
+	Source protocol.SwitchSource
+}
+
+type MeasurementEvent struct {
+	Device string
+	Outlet int
+	Time   time.Time
+	protocol.Measurement
+}
+
+// Handlers is an EventHandler decoding events into typed events, nil
+// callbacks are skipped.
+type Handlers struct {
+	Connected    func(ConnectedEvent)
+	Disconnected func(DisconnectedEvent)
+	Switch       func(SwitchEvent)
+	Measurement  func(MeasurementEvent)
+}
+
+func (h Handlers) HandleEvent(e Event) {
+	switch e.Kind {
+	case EventConnected:
+		if h.Connected == nil {
+			return
+		}
+		connected := ConnectedEvent{Device: e.Device, Time: e.Time}
+		if e.device != nil {
+			connected.FirmwareVersion = e.device.FirmwareVersion
+			connected.RemoteAddress = e.device.RemoteAddr()
+		}
+		h.Connected(connected)
+	case EventDisconnected:
+		if h.Disconnected != nil {
+			h.Disconnected(DisconnectedEvent{Device: e.Device, Time: e.Time})
+		}
+	case EventMessage:
+		if e.Message == nil || e.Message.Status == nil {
+			return
+		}
+		status := e.Message.Status
+		if status.Switch != nil && h.Switch != nil {
+			h.Switch(SwitchEvent{Device: e.Device, Outlet: status.Outlet, Time: e.Time, On: *status.Switch, Source: status.Source})
+		}
+		if status.Energy != nil && h.Measurement != nil {
+			h.Measurement(MeasurementEvent{Device: e.Device, Outlet: status.Outlet, Time: e.Time, Measurement: status.Energy.Measurement()})
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package ghoma
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+func TestHandlers(t *testing.T) {
+	var (
+		connected    []ConnectedEvent
+		disconnected []DisconnectedEvent
+		switches     []SwitchEvent
+	)
+	h := Handlers{
+		Connected:    func(e ConnectedEvent) { connected = append(connected, e) },
+		Disconnected: func(e DisconnectedEvent) { disconnected = append(disconnected, e) },
+		Switch:       func(e SwitchEvent) { switches = append(switches, e) },
+	}
+	now := time.Now()
+
+	h.HandleEvent(Event{Kind: EventConnected, Device: "d78a1c", Time: now})
+	h.HandleEvent(Event{Kind: EventMessage, Device: "d78a1c", Time: now, Message: switchStatus(true)})
+	h.HandleEvent(Event{Kind: EventMessage, Device: "d78a1c", Time: now, Message: &protocol.Message{Command: protocol.CmdHeartBeat}})
+	h.HandleEvent(Event{Kind: EventDisconnected, Device: "d78a1c", Time: now})
+
+	require.Equal(t, []ConnectedEvent{{Device: "d78a1c", Time: now}}, connected)
+	require.Equal(t, []SwitchEvent{{Device: "d78a1c", Outlet: 1, Time: now, On: true}}, switches)
+	require.Equal(t, []DisconnectedEvent{{Device: "d78a1c", Time: now}}, disconnected)
+}
@@ -0,0 +1,56 @@
+package ghomatest
+
+import (
+	"sync"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+// Status is a call of HandleStatus.
+type Status struct {
+	Device  string
+	Message protocol.Message
+}
+
+// Recorder is a status and event handler keeping every call it receives.
+type Recorder struct {
+	mu       sync.Mutex
+	statuses []Status
+	events   []ghoma.Event
+}
+
+func (r *Recorder) HandleStatus(dev *ghoma.Device, msg protocol.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, Status{Device: dev.ID, Message: msg})
+}
+
+func (r *Recorder) HandleEvent(e ghoma.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *Recorder) Statuses() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Status(nil), r.statuses...)
+}
+
+func (r *Recorder) Events() []ghoma.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ghoma.Event(nil), r.events...)
+}
+
+// EventKinds returns the kind of every event received, in order.
+func (r *Recorder) EventKinds() []ghoma.EventKind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kinds := make([]ghoma.EventKind, 0, len(r.events))
+	for _, e := range r.events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
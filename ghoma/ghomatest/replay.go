@@ -0,0 +1,105 @@
+package ghomatest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+type Options struct {
+	// Timeout is how long to wait for an expected frame, 1s by default.
+	Timeout time.Duration
+}
+
+type Result struct {
+	// Frames are every frame written by the server, expected or not.
+	Frames [][]byte
+}
+
+// Messages parses the frames written by the server.
+func (r Result) Messages() ([]*protocol.Message, error) {
+	messages := make([]*protocol.Message, 0, len(r.Frames))
+	for _, frame := range r.Frames {
+		msg, err := protocol.ReadMessage(bytes.NewReader(frame))
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Replay plays the script as a plug connected to the server through a
+// net.Pipe and stops at the first frame that differs from the script. The
+// connection is then closed and Replay waits for the server to be done with
+// it, so every handler has been called once it returns.
+func Replay(server *ghoma.Server, script Script, options Options) (Result, error) {
+	if options.Timeout <= 0 {
+		options.Timeout = time.Second
+	}
+
+	plug, conn := net.Pipe()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		server.ServeConn(conn)
+	}()
+
+	// Frames are read as soon as they are written so the server never
+	// blocks on the pipe while the plug sends.
+	frames := make(chan []byte, 64)
+	go func() {
+		defer close(frames)
+		reader := bufio.NewReader(plug)
+		for {
+			frame, err := protocol.ReadFrame(reader)
+			if len(frame) > 0 {
+				frames <- frame
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var res Result
+	err := play(plug, script, frames, &res, options.Timeout)
+	plug.Close()
+	<-served
+	for frame := range frames {
+		res.Frames = append(res.Frames, frame)
+	}
+	return res, err
+}
+
+func play(plug net.Conn, script Script, frames <-chan []byte, res *Result, timeout time.Duration) error {
+	for i, step := range script {
+		switch step.Action {
+		case Send:
+			if _, err := plug.Write(step.Frame); err != nil {
+				return fmt.Errorf("step %d (%s): %w", i+1, step, err)
+			}
+		case Expect:
+			select {
+			case frame, ok := <-frames:
+				if !ok {
+					return fmt.Errorf("step %d (%s): connection closed by server", i+1, step)
+				}
+				res.Frames = append(res.Frames, frame)
+				if !bytes.Equal(frame, step.Frame) {
+					return fmt.Errorf("step %d (%s): got %x", i+1, step, frame)
+				}
+			case <-time.After(timeout):
+				return fmt.Errorf("step %d (%s): no frame after %s", i+1, step, timeout)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown action %q", i+1, step.Action)
+		}
+	}
+	return nil
+}
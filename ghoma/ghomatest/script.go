@@ -0,0 +1,110 @@
+// Package ghomatest replays plug conversations against a ghoma.Server, to
+// pin the behaviour of the server for every firmware version.
+//
+// Conversations are read from wire traces, see package trace, or from
+// hand-written scripts with a step per line:
+//
+//	# INIT1, answered with the device ID
+//	expect 02050d07050712
+//	send   03010ac03223d78a1c0100
+//
+// "expect" waits for the server to write a frame with the given payload,
+// "send" writes a frame with the given payload as the plug. Payloads are in
+// hex, spaces are ignored and comments start with #.
+package ghomatest
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eliecharra/ghoma/protocol"
+	"github.com/eliecharra/ghoma/trace"
+)
+
+type Action string
+
+const (
+	// Send writes the frame as the plug.
+	Send Action = "send"
+	// Expect waits for the server to write the frame.
+	Expect Action = "expect"
+)
+
+type Step struct {
+	Action Action
+	Frame  []byte
+}
+
+func (s Step) String() string {
+	return fmt.Sprintf("%s %x", s.Action, s.Frame)
+}
+
+// Script is a plug conversation, in the order frames went over the wire.
+type Script []Step
+
+// FromTrace returns the conversation recorded in a trace, frames read by the
+// server are sent and frames it wrote are expected. Frames that could not
+// be read whole are sent as they were read.
+func FromTrace(records []trace.Record) Script {
+	script := make(Script, 0, len(records))
+	for _, r := range records {
+		action := Send
+		if r.Direction == trace.Out {
+			action = Expect
+		}
+		script = append(script, Step{Action: action, Frame: r.Frame})
+	}
+	return script
+}
+
+// ParseScript reads a hand-written script.
+func ParseScript(r io.Reader) (Script, error) {
+	var script Script
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		action := Action(fields[0])
+		if action != Send && action != Expect {
+			return nil, fmt.Errorf("line %d: unknown action %q", line, fields[0])
+		}
+		payload, err := hex.DecodeString(strings.Join(fields[1:], ""))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(payload) == 0 {
+			return nil, fmt.Errorf("line %d: empty payload", line)
+		}
+		script = append(script, Step{Action: action, Frame: protocol.Message{Payload: payload}.ToBytes()})
+	}
+	return script, scanner.Err()
+}
+
+// ReadFile reads a conversation from a trace when the file name ends with
+// .jsonl, from a script otherwise.
+func ReadFile(path string) (Script, error) {
+	if filepath.Ext(path) == ".jsonl" {
+		records, err := trace.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return FromTrace(records), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseScript(f)
+}
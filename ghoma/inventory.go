@@ -0,0 +1,29 @@
+package ghoma
+
+// InventoryEntry is a known device along with flags about its firmware.
+type InventoryEntry struct {
+	DeviceInfo
+	Online bool `json:"online"`
+	// FirmwareChanged is set once the device registered with more than one
+	// firmware version.
+	FirmwareChanged bool `json:"firmware_changed"`
+	// FirmwareDrift is set when the device does not run the expected
+	// firmware, see ServerOptions.ExpectedFirmware.
+	FirmwareDrift bool `json:"firmware_drift"`
+}
+
+// Inventory returns every known device sorted by ID, see Known.
+func (s *Server) Inventory() []InventoryEntry {
+	known := s.Known()
+	inventory := make([]InventoryEntry, 0, len(known))
+	for _, info := range known {
+		_, online := s.Device(info.ID)
+		inventory = append(inventory, InventoryEntry{
+			DeviceInfo:      info,
+			Online:          online,
+			FirmwareChanged: len(info.Firmwares) > 1,
+			FirmwareDrift:   s.options.ExpectedFirmware != "" && info.FirmwareVersion != s.options.ExpectedFirmware,
+		})
+	}
+	return inventory
+}
@@ -0,0 +1,361 @@
+package ghoma
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// deviceField is the field every device logger is tagged with.
+const deviceField = "device_id"
+
+// LogLevels holds the log level of the server and the levels overriding it
+// for single devices, both can be changed at runtime.
+type LogLevels struct {
+	level zap.AtomicLevel
+
+	mu      sync.RWMutex
+	devices map[string]zapcore.Level
+}
+
+func NewLogLevels(level zapcore.Level) *LogLevels {
+	return &LogLevels{
+		level:   zap.NewAtomicLevelAt(level),
+		devices: make(map[string]zapcore.Level),
+	}
+}
+
+func (l *LogLevels) Level() zapcore.Level {
+	return l.level.Level()
+}
+
+func (l *LogLevels) SetLevel(level zapcore.Level) {
+	l.level.SetLevel(level)
+}
+
+// DeviceLevels returns the level overrides by device ID.
+func (l *LogLevels) DeviceLevels() map[string]zapcore.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	devices := make(map[string]zapcore.Level, len(l.devices))
+	for id, level := range l.devices {
+		devices[id] = level
+	}
+	return devices
+}
+
+// SetDeviceLevel overrides the level of the logs of a single device.
+func (l *LogLevels) SetDeviceLevel(id string, level zapcore.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.devices[strings.ToLower(id)] = level
+}
+
+// ResetDeviceLevel logs the device at the server level again.
+func (l *LogLevels) ResetDeviceLevel(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.devices, strings.ToLower(id))
+}
+
+// ParseDeviceLevels sets the overrides given as id=level, e.g. d78a1c=debug.
+func (l *LogLevels) ParseDeviceLevels(overrides []string) error {
+	for _, override := range overrides {
+		if override == "" {
+			continue
+		}
+		id, name, found := strings.Cut(override, "=")
+		if !found {
+			return fmt.Errorf("invalid device log level %q, expected id=level", override)
+		}
+		level, err := zapcore.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		l.SetDeviceLevel(id, level)
+	}
+	return nil
+}
+
+func (l *LogLevels) enabled(device string, level zapcore.Level) bool {
+	if device != "" {
+		l.mu.RLock()
+		override, exist := l.devices[device]
+		l.mu.RUnlock()
+		if exist {
+			return override.Enabled(level)
+		}
+	}
+	return l.level.Enabled(level)
+}
+
+// Core filters the entries of core with the levels, core itself must be
+// enabled for every level. Use it with zap.WrapCore.
+func (l *LogLevels) Core(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core, levels: l}
+}
+
+type logLevels struct {
+	Level   string            `json:"level,omitempty"`
+	Devices map[string]string `json:"devices"`
+}
+
+// ServeHTTP reports the levels on GET and changes them on PUT, with a body
+// like {"level":"info","devices":{"d78a1c":"debug"}}. Devices missing from
+// the body are left untouched, an empty level removes the override.
+func (l *LogLevels) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req logLevels
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := l.apply(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res := logLevels{Level: l.Level().String(), Devices: make(map[string]string)}
+	for id, level := range l.DeviceLevels() {
+		res.Devices[id] = level.String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// apply validates every level before changing any.
+func (l *LogLevels) apply(req logLevels) error {
+	level := l.Level()
+	if req.Level != "" {
+		var err error
+		if level, err = zapcore.ParseLevel(req.Level); err != nil {
+			return err
+		}
+	}
+	devices := make(map[string]*zapcore.Level, len(req.Devices))
+	for id, name := range req.Devices {
+		if name == "" {
+			devices[id] = nil
+			continue
+		}
+		override, err := zapcore.ParseLevel(name)
+		if err != nil {
+			return err
+		}
+		devices[id] = &override
+	}
+
+	l.SetLevel(level)
+	for id, override := range devices {
+		if override == nil {
+			l.ResetDeviceLevel(id)
+		} else {
+			l.SetDeviceLevel(id, *override)
+		}
+	}
+	return nil
+}
+
+// levelCore is bound to the device of the logger it belongs to once the
+// device field is added to it.
+type levelCore struct {
+	zapcore.Core
+	levels *LogLevels
+	device string
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.levels.enabled(c.device, level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels, device: deviceOf(fields, c.device)}
+}
+
+func (c *levelCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(e.Level) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// deviceOf returns the device the fields tag a logger with, or device when
+// they do not.
+func deviceOf(fields []zapcore.Field, device string) string {
+	for _, f := range fields {
+		if f.Key == deviceField && f.Type == zapcore.StringType {
+			device = strings.ToLower(f.String)
+		}
+	}
+	return device
+}
+
+// DeviceSampler samples the logs of every device on its own, so a single
+// chatty plug can neither flood the logs nor get the others sampled out.
+// Every tick, the first entries of a device with a given level and message
+// are logged, then one in thereafter. Logs of the server itself are kept.
+type DeviceSampler struct {
+	tick              time.Duration
+	first, thereafter uint64
+
+	mu     sync.Mutex
+	counts map[sampleKey]*sampleCount
+}
+
+type sampleKey struct {
+	device  string
+	level   zapcore.Level
+	message string
+}
+
+type sampleCount struct {
+	since time.Time
+	n     uint64
+}
+
+// NewDeviceSampler drops every entry past the first ones when thereafter is
+// zero.
+func NewDeviceSampler(tick time.Duration, first, thereafter int) *DeviceSampler {
+	return &DeviceSampler{
+		tick:       tick,
+		first:      uint64(max(first, 0)),
+		thereafter: uint64(max(thereafter, 0)),
+		counts:     make(map[sampleKey]*sampleCount),
+	}
+}
+
+// Core samples the entries of core. Use it with zap.WrapCore.
+func (s *DeviceSampler) Core(core zapcore.Core) zapcore.Core {
+	return &sampleCore{Core: core, sampler: s}
+}
+
+func (s *DeviceSampler) sample(device string, e zapcore.Entry) bool {
+	key := sampleKey{device: device, level: e.Level, message: e.Message}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, exist := s.counts[key]
+	if !exist || e.Time.Sub(c.since) >= s.tick {
+		if !exist && len(s.counts) >= maxSampleCounts {
+			s.expire(e.Time)
+		}
+		c = &sampleCount{since: e.Time}
+		s.counts[key] = c
+	}
+	c.n++
+	return c.n <= s.first || (s.thereafter > 0 && (c.n-s.first)%s.thereafter == 0)
+}
+
+// maxSampleCounts bounds the counts kept, stale ones are expired past it.
+const maxSampleCounts = 4096
+
+func (s *DeviceSampler) expire(now time.Time) {
+	for key, c := range s.counts {
+		if now.Sub(c.since) >= s.tick {
+			delete(s.counts, key)
+		}
+	}
+}
+
+// sampleCore is bound to the device of the logger it belongs to, like
+// levelCore.
+type sampleCore struct {
+	zapcore.Core
+	sampler *DeviceSampler
+	device  string
+}
+
+func (c *sampleCore) With(fields []zapcore.Field) zapcore.Core {
+	return &sampleCore{Core: c.Core.With(fields), sampler: c.sampler, device: deviceOf(fields, c.device)}
+}
+
+func (c *sampleCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.device != "" && c.Enabled(e.Level) && !c.sampler.sample(c.device, e) {
+		return ce
+	}
+	return c.Core.Check(e, ce)
+}
+
+// NewSlogLogger returns a logger writing to a log/slog handler, to embed the
+// server in applications logging with slog.
+func NewSlogLogger(h slog.Handler) *zap.Logger {
+	return zap.New(&slogCore{handler: h})
+}
+
+type slogCore struct {
+	handler slog.Handler
+}
+
+func (c *slogCore) Enabled(level zapcore.Level) bool {
+	return c.handler.Enabled(context.Background(), slogLevel(level))
+}
+
+func (c *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &slogCore{handler: c.handler.WithAttrs(slogAttrs(fields))}
+}
+
+func (c *slogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *slogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	r := slog.NewRecord(e.Time, slogLevel(e.Level), e.Message, 0)
+	if e.LoggerName != "" {
+		r.AddAttrs(slog.String("logger", e.LoggerName))
+	}
+	r.AddAttrs(slogAttrs(fields)...)
+	return c.handler.Handle(context.Background(), r)
+}
+
+func (c *slogCore) Sync() error {
+	return nil
+}
+
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
+
+// slogAttrs converts fields in order, through the encoding zap would use.
+func slogAttrs(fields []zapcore.Field) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		keys := make([]string, 0, len(enc.Fields))
+		for k := range enc.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			attrs = append(attrs, slog.Any(k, enc.Fields[k]))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,140 @@
+package ghoma
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogLevels_Device(t *testing.T) {
+	levels := NewLogLevels(zapcore.InfoLevel)
+	require.NoError(t, levels.ParseDeviceLevels([]string{"D78A1C=debug", "0a0b0c=error"}))
+	require.Error(t, levels.ParseDeviceLevels([]string{"d78a1c"}))
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core, zap.WrapCore(levels.Core))
+
+	logger.Debug("server")
+	logger.With(zap.String(deviceField, "d78a1c")).Debug("debug device")
+	logger.With(zap.String(deviceField, "0a0b0c")).Warn("error device")
+	logger.With(zap.String(deviceField, "112233")).Info("other device")
+
+	levels.ResetDeviceLevel("d78a1c")
+	logger.With(zap.String(deviceField, "d78a1c")).Debug("reset device")
+
+	var messages []string
+	for _, e := range logs.All() {
+		messages = append(messages, e.Message)
+	}
+	require.Equal(t, []string{"debug device", "other device"}, messages)
+}
+
+func TestDeviceSampler(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core, zap.WrapCore(NewDeviceSampler(time.Hour, 2, 3).Core))
+
+	chatty := logger.With(zap.String(deviceField, "d78a1c"))
+	quiet := logger.With(zap.String(deviceField, "0a0b0c"))
+	for i := 1; i <= 8; i++ {
+		chatty.Info("heartbeat", zap.Int("n", i))
+		logger.Info("server", zap.Int("n", i))
+	}
+	quiet.Info("heartbeat", zap.Int("n", 1))
+
+	var entries []string
+	for _, e := range logs.All() {
+		entries = append(entries, fmt.Sprintf("%s %v %v", e.Message, e.ContextMap()[deviceField], e.ContextMap()["n"]))
+	}
+	require.Equal(t, []string{
+		"heartbeat d78a1c 1", "server <nil> 1",
+		"heartbeat d78a1c 2", "server <nil> 2",
+		"server <nil> 3",
+		"server <nil> 4",
+		"heartbeat d78a1c 5", "server <nil> 5",
+		"server <nil> 6",
+		"server <nil> 7",
+		"heartbeat d78a1c 8", "server <nil> 8",
+		"heartbeat 0a0b0c 1",
+	}, entries)
+}
+
+func TestLogLevels_ServeHTTP(t *testing.T) {
+	levels := NewLogLevels(zapcore.InfoLevel)
+	levels.SetDeviceLevel("0a0b0c", zapcore.WarnLevel)
+
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "get",
+			method:       http.MethodGet,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"level":"info","devices":{"0a0b0c":"warn"}}`,
+		},
+		{
+			name:         "set device and reset another",
+			method:       http.MethodPut,
+			body:         `{"devices":{"d78a1c":"debug","0a0b0c":""}}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"level":"info","devices":{"d78a1c":"debug"}}`,
+		},
+		{
+			name:         "invalid level changes nothing",
+			method:       http.MethodPut,
+			body:         `{"level":"warn","devices":{"d78a1c":"loud"}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "set level",
+			method:       http.MethodPut,
+			body:         `{"level":"warn"}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"level":"warn","devices":{"d78a1c":"debug"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			levels.ServeHTTP(rec, httptest.NewRequest(tt.method, "/debug/log-level", strings.NewReader(tt.body)))
+			require.Equal(t, tt.expectedCode, rec.Code)
+			if tt.expectedBody != "" {
+				require.JSONEq(t, tt.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestNewSlogLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewSlogLogger(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	logger.Debug("dropped")
+	logger.With(zap.String(deviceField, "d78a1c")).Warn("read error", zap.Error(errors.New("EOF")), zap.Int("attempt", 2))
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	delete(record, "time")
+	require.Equal(t, map[string]any{
+		"level":     "WARN",
+		"msg":       "read error",
+		"device_id": "d78a1c",
+		"error":     "EOF",
+		"attempt":   float64(2),
+	}, record)
+}
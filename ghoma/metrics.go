@@ -0,0 +1,202 @@
+package ghoma
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ns        = "ghoma"
+	subsystem = "server"
+)
+
+type serverMetrics struct {
+	commands          *prometheus.CounterVec
+	connections       prometheus.Counter
+	handshakeFailures *prometheus.CounterVec
+	messages          *prometheus.CounterVec
+	bytes             *prometheus.CounterVec
+	heartbeatLatency  prometheus.Histogram
+	reconnects        *prometheus.CounterVec
+	rejections        *prometheus.CounterVec
+	switchChanges     *prometheus.CounterVec
+	unknownFrames     *prometheus.CounterVec
+
+	devicesConnected *prometheus.Desc
+	uptime           *prometheus.Desc
+	deviceInfo       *prometheus.Desc
+	firmwareDrift    *prometheus.Desc
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "commands_total",
+			Help:      "commands sent to devices by result (acked, timed_out, offline, canceled)",
+		}, []string{"result"}),
+		connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "connections_total",
+			Help:      "TCP connections accepted from devices",
+		}),
+		handshakeFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "handshake_failures_total",
+			Help:      "connections dropped during the handshake by stage (init1, init1_ack, init2, firmware)",
+		}, []string{"stage"}),
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "messages_total",
+			Help:      "messages received from registered devices by command",
+		}, []string{"device", "command"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "bytes_total",
+			Help:      "bytes exchanged with devices by direction (received, sent)",
+		}, []string{"direction"}),
+		heartbeatLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "heartbeat_latency_seconds",
+			Help:      "time between receiving a heartbeat and writing its reply",
+			Buckets:   []float64{.0005, .001, .005, .01, .05, .1, .5, 1},
+		}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: "device",
+			Name:      "reconnects_total",
+			Help:      "sessions closed because the device connected again before they died",
+		}, []string{"device"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "rejected_connections_total",
+			Help:      "connections rejected by reason (network, rate, connections, connections_per_ip, device, handshake_timeout)",
+		}, []string{"reason"}),
+		switchChanges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Name:      "switch_changes_total",
+			Help:      "switch state changes reported by devices by source (button, remote, timer, power_on, unknown), timer and power_on are inferred by the server, not decoded from the payload",
+		}, []string{"device", "source"}),
+		unknownFrames: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: subsystem,
+			Name:      "unknown_frames_total",
+			Help:      "frames received from registered devices that could not be decoded by kind (command, status)",
+		}, []string{"device", "kind"}),
+		devicesConnected: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "devices_connected"),
+			"devices currently registered",
+			nil, nil,
+		),
+		uptime: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "connection_uptime_seconds"),
+			"seconds since the device connection was accepted",
+			[]string{"device"}, nil,
+		),
+		deviceInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "device", "info"),
+			"firmware of every device that registered, always 1",
+			[]string{"device", "firmware"}, nil,
+		),
+		firmwareDrift: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, "device", "firmware_drift"),
+			"whether the device runs another firmware than the expected one (0 = expected, 1 = other)",
+			[]string{"device"}, nil,
+		),
+	}
+}
+
+func (m *serverMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.commands,
+		m.connections,
+		m.handshakeFailures,
+		m.messages,
+		m.bytes,
+		m.heartbeatLatency,
+		m.reconnects,
+		m.rejections,
+		m.switchChanges,
+		m.unknownFrames,
+	}
+}
+
+// countingConn counts the bytes read from and written to a device.
+type countingConn struct {
+	net.Conn
+	received, sent prometheus.Counter
+}
+
+func (m *serverMetrics) count(c net.Conn) net.Conn {
+	return &countingConn{
+		Conn:     c,
+		received: m.bytes.WithLabelValues("received"),
+		sent:     m.bytes.WithLabelValues("sent"),
+	}
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(float64(n))
+	return n, err
+}
+
+func resultLabel(err error) string {
+	switch {
+	case err == nil:
+		return "acked"
+	case errors.Is(err, ErrCommandTimeout):
+		return "timed_out"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "offline"
+	}
+}
+
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range s.metrics.collectors() {
+		c.Describe(ch)
+	}
+	ch <- s.metrics.devicesConnected
+	ch <- s.metrics.uptime
+	ch <- s.metrics.deviceInfo
+	ch <- s.metrics.firmwareDrift
+}
+
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range s.metrics.collectors() {
+		c.Collect(ch)
+	}
+	ch <- prometheus.MustNewConstMetric(s.metrics.devicesConnected, prometheus.GaugeValue, float64(s.devicesCount.Load()))
+	for _, dev := range s.Devices() {
+		ch <- prometheus.MustNewConstMetric(s.metrics.uptime, prometheus.GaugeValue, time.Since(dev.connectedAt).Seconds(), dev.ID)
+	}
+	for _, entry := range s.Inventory() {
+		ch <- prometheus.MustNewConstMetric(s.metrics.deviceInfo, prometheus.GaugeValue, 1, entry.ID, entry.FirmwareVersion)
+		if s.options.ExpectedFirmware == "" {
+			continue
+		}
+		var drift float64
+		if entry.FirmwareDrift {
+			drift = 1
+		}
+		ch <- prometheus.MustNewConstMetric(s.metrics.firmwareDrift, prometheus.GaugeValue, drift, entry.ID)
+	}
+}
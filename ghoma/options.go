@@ -0,0 +1,77 @@
+package ghoma
+
+import (
+	"log/slog"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithServerOptions replaces every setting of the server, options given
+// after it still apply.
+func WithServerOptions(options ServerOptions) Option {
+	return func(s *Server) {
+		s.options = options
+	}
+}
+
+// WithListenAddr sets the TCP address plugs connect to.
+func WithListenAddr(addr string) Option {
+	return func(s *Server) {
+		s.options.ListenAddr = addr
+	}
+}
+
+// WithLogger sets the logger of the server and its devices, zap.L() is used
+// when none is given.
+func WithLogger(logger *zap.Logger) Option {
+	return func(s *Server) {
+		s.options.Logger = logger
+	}
+}
+
+// WithCommandTimeout sets how long to wait for a device to acknowledge a
+// command and how many times it is sent again.
+func WithCommandTimeout(timeout time.Duration, retries int) Option {
+	return func(s *Server) {
+		s.options.CommandTimeout = timeout
+		s.options.CommandRetries = retries
+	}
+}
+
+// WithHandshakeTimeout bounds the time a client has to register.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.options.HandshakeTimeout = timeout
+	}
+}
+
+// WithStatusHandler adds a handler receiving every status message.
+func WithStatusHandler(h StatusHandler) Option {
+	return func(s *Server) {
+		s.handlers = append(s.handlers, h)
+	}
+}
+
+// WithEventHandler adds a handler receiving every server event.
+func WithEventHandler(h EventHandler) Option {
+	return func(s *Server) {
+		s.eventHandlers = append(s.eventHandlers, h)
+	}
+}
+
+// WithExpectedFirmware flags the devices not running the given firmware
+// version in the inventory.
+func WithExpectedFirmware(version string) Option {
+	return func(s *Server) {
+		s.options.ExpectedFirmware = version
+	}
+}
+
+// WithSlogHandler sets the logger of the server to one writing to h.
+func WithSlogHandler(h slog.Handler) Option {
+	return WithLogger(NewSlogLogger(h))
+}
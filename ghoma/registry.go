@@ -0,0 +1,114 @@
+package ghoma
+
+import (
+	"sort"
+	"time"
+)
+
+// DeviceInfo is what the server remembers of a device that registered,
+// whether it is still connected or not.
+type DeviceInfo struct {
+	ID              string `json:"id"`
+	FirmwareVersion string `json:"firmware_version"`
+	// RemoteAddress is the address the device last connected from.
+	RemoteAddress string    `json:"remote_address"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	FirstSeen     time.Time `json:"first_seen"`
+	// LastSeen is when the device last registered or disconnected.
+	LastSeen time.Time `json:"last_seen"`
+	// Firmwares are the versions the device registered with, oldest first.
+	Firmwares []Firmware `json:"firmwares,omitempty"`
+}
+
+type Firmware struct {
+	Version string    `json:"version"`
+	Since   time.Time `json:"since"`
+}
+
+// remember records the registration of dev and returns the firmware version
+// it registered with before, if any. Unless it took over a session, dev
+// starts from the switch states the last session ended with.
+func (s *Server) remember(dev *Device) (previous string) {
+	s.knownMu.Lock()
+	defer s.knownMu.Unlock()
+	info, exist := s.known[dev.ID]
+	if exist {
+		previous = info.FirmwareVersion
+	} else {
+		info.FirstSeen = dev.connectedAt
+	}
+	if dev.previous == nil {
+		dev.previous = s.switches[dev.ID]
+	}
+	info.ID = dev.ID
+	info.FirmwareVersion = dev.FirmwareVersion
+	info.RemoteAddress = dev.RemoteAddr()
+	info.ConnectedAt = dev.connectedAt
+	info.LastSeen = dev.connectedAt
+	if n := len(info.Firmwares); n == 0 || info.Firmwares[n-1].Version != dev.FirmwareVersion {
+		info.Firmwares = append(info.Firmwares, Firmware{Version: dev.FirmwareVersion, Since: dev.connectedAt})
+	}
+	s.known[dev.ID] = info
+	return previous
+}
+
+// seen records that the session of dev ended.
+func (s *Server) seen(dev *Device) {
+	s.knownMu.Lock()
+	defer s.knownMu.Unlock()
+	if info, exist := s.known[dev.ID]; exist {
+		info.LastSeen = time.Now()
+		s.known[dev.ID] = info
+	}
+	s.switches[dev.ID] = dev.switches()
+}
+
+// Known returns every device that registered, sorted by ID, including the
+// ones restored from a previous run.
+func (s *Server) Known() []DeviceInfo {
+	s.knownMu.Lock()
+	defer s.knownMu.Unlock()
+	devices := make([]DeviceInfo, 0, len(s.known))
+	for _, info := range s.known {
+		info.Firmwares = append([]Firmware(nil), info.Firmwares...)
+		devices = append(devices, info)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+	return devices
+}
+
+// Restore adds devices known from a previous run, devices that registered
+// since keep their current state on top of their restored history.
+func (s *Server) Restore(devices []DeviceInfo) {
+	s.knownMu.Lock()
+	defer s.knownMu.Unlock()
+	for _, info := range devices {
+		if info.ID == "" {
+			continue
+		}
+		// Snapshots written before the inventory have no history
+		if info.FirstSeen.IsZero() {
+			info.FirstSeen = info.ConnectedAt
+		}
+		if len(info.Firmwares) == 0 && info.FirmwareVersion != "" {
+			info.Firmwares = []Firmware{{Version: info.FirmwareVersion, Since: info.ConnectedAt}}
+		}
+
+		current, exist := s.known[info.ID]
+		if !exist {
+			s.known[info.ID] = info
+			continue
+		}
+		current.FirstSeen = info.FirstSeen
+		firmwares := info.Firmwares
+		for _, f := range current.Firmwares {
+			if n := len(firmwares); n == 0 || firmwares[n-1].Version != f.Version {
+				firmwares = append(firmwares, f)
+			}
+		}
+		current.Firmwares = firmwares
+		s.known[info.ID] = current
+	}
+}
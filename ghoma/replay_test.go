@@ -0,0 +1,186 @@
+package ghoma_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/ghoma/ghomatest"
+	"github.com/eliecharra/ghoma/protocol"
+	"github.com/eliecharra/ghoma/trace"
+)
+
+func TestReplay_Firmware(t *testing.T) {
+	scripts, err := filepath.Glob("testdata/firmware-*.script")
+	require.NoError(t, err)
+	require.NotEmpty(t, scripts)
+
+	for _, path := range scripts {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			script, err := ghomatest.ReadFile(path)
+			require.NoError(t, err)
+
+			rec := &ghomatest.Recorder{}
+			server := ghoma.NewServer(ghoma.WithStatusHandler(rec), ghoma.WithEventHandler(rec))
+			_, err = ghomatest.Replay(server, script, ghomatest.Options{})
+			require.NoError(t, err)
+
+			require.Equal(t, []ghoma.EventKind{
+				ghoma.EventConnected,
+				ghoma.EventMessage, // heartbeat
+				ghoma.EventMessage, // switch
+				ghoma.EventMessage, // power
+				ghoma.EventDisconnected,
+			}, rec.EventKinds())
+
+			statuses := rec.Statuses()
+			require.Len(t, statuses, 2)
+			require.Equal(t, "d78a1c", statuses[0].Device)
+			require.NotNil(t, statuses[0].Message.Status.Switch)
+			require.True(t, *statuses[0].Message.Status.Switch)
+			require.Equal(t, protocol.SourcePowerOn, statuses[0].Message.Status.Source)
+			require.Equal(t, protocol.Measurement{Kind: "POWER", Value: 44.04, Unit: "W", Verified: true}, statuses[1].Message.Status.Energy.Measurement())
+
+			require.NoError(t, testutil.CollectAndCompare(server, strings.NewReader(`
+# HELP ghoma_server_messages_total messages received from registered devices by command
+# TYPE ghoma_server_messages_total counter
+ghoma_server_messages_total{command="HEARTHBEAT",device="d78a1c"} 1
+ghoma_server_messages_total{command="STATUS",device="d78a1c"} 2
+`), "ghoma_server_messages_total"))
+		})
+	}
+}
+
+func TestReplay_Trace(t *testing.T) {
+	script, err := ghomatest.ReadFile("testdata/firmware-1.1.6.script")
+	require.NoError(t, err)
+
+	// Record the conversation, then replay the trace
+	dir := t.TempDir()
+	server := ghoma.NewServer(ghoma.WithServerOptions(ghoma.ServerOptions{
+		Trace: ghoma.TraceOptions{Dir: dir, Devices: []string{"d78a1c"}},
+	}))
+	res, err := ghomatest.Replay(server, script, ghomatest.Options{})
+	require.NoError(t, err)
+	require.NoError(t, server.StopTrace("d78a1c"))
+
+	records, err := trace.ReadFile(filepath.Join(dir, "d78a1c.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, records, len(script))
+	_, err = ghomatest.Replay(ghoma.NewServer(), ghomatest.FromTrace(records), ghomatest.Options{})
+	require.NoError(t, err)
+
+	messages, err := res.Messages()
+	require.NoError(t, err)
+	var commands []protocol.Command
+	for _, msg := range messages {
+		commands = append(commands, msg.Command)
+	}
+	require.Equal(t, []protocol.Command{protocol.CmdInit1, protocol.CmdInit1, protocol.CmdInit2, protocol.CmdHeartBeatReply}, commands)
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	script, err := ghomatest.ParseScript(strings.NewReader(`
+expect 02 05 0d 07 05 07 12
+send   03 01 0a c0 32 23 d7 8a 1c 01 00
+expect 05 01 # INIT1 ACK comes first
+`))
+	require.NoError(t, err)
+
+	_, err = ghomatest.Replay(ghoma.NewServer(), script, ghomatest.Options{})
+	require.ErrorContains(t, err, "step 3 (expect 5aa500020501f95bb5): got 5aa5000102fd5bb5")
+}
+
+func TestReplay_UnknownFrames(t *testing.T) {
+	script, err := ghomatest.ParseScript(strings.NewReader(`
+expect 02 05 0d 07 05 07 12
+send   03 01 0a c0 32 23 d7 8a 1c 01 00
+expect 02
+expect 05 01
+send   07 01
+send   07 01 0a e0 32 23 d7 8a 1c 01 01 06
+send   ee 01 02                                                 # unknown command
+send   ee 01 02
+send   90 01 0a e0 32 23 d7 8a 1c ff fe 01 11 11 00 00 01 00 00 00 42  # unknown switch state
+send   90 01 0a e0 32 23 d7 8a 1c ff fe 01 11 11 00 00 01 00 00 00 ff
+`))
+	require.NoError(t, err)
+
+	server := ghoma.NewServer()
+	_, err = ghomatest.Replay(server, script, ghomatest.Options{})
+	require.NoError(t, err)
+
+	frames := server.UnknownFrames()
+	require.Len(t, frames, 2)
+	require.Equal(t, "90010ae03223d78a1cfffe01111100000100000042", frames[0].Payload)
+	require.Equal(t, ghoma.UnknownStatus, frames[0].Kind)
+	require.Equal(t, uint64(1), frames[0].Count)
+	require.Equal(t, "ee0102", frames[1].Payload)
+	require.Equal(t, ghoma.UnknownCommand, frames[1].Kind)
+	require.Equal(t, uint64(2), frames[1].Count)
+	require.Equal(t, "d78a1c", frames[1].Device)
+	require.Equal(t, "1.1.6", frames[1].FirmwareVersion)
+
+	require.NoError(t, testutil.CollectAndCompare(server, strings.NewReader(`
+# HELP ghoma_server_unknown_frames_total frames received from registered devices that could not be decoded by kind (command, status)
+# TYPE ghoma_server_unknown_frames_total counter
+ghoma_server_unknown_frames_total{device="d78a1c",kind="command"} 2
+ghoma_server_unknown_frames_total{device="d78a1c",kind="status"} 1
+`), "ghoma_server_unknown_frames_total"))
+}
+
+func TestReplay_Inventory(t *testing.T) {
+	session := func(firmware string) ghomatest.Script {
+		script, err := ghomatest.ParseScript(strings.NewReader(`
+expect 02 05 0d 07 05 07 12
+send   03 01 0a c0 32 23 d7 8a 1c 01 00
+expect 02
+expect 05 01
+send   07 01
+send   07 01 0a e0 32 23 d7 8a 1c ` + firmware))
+		require.NoError(t, err)
+		return script
+	}
+
+	server := ghoma.NewServer(ghoma.WithExpectedFirmware("1.1.7"))
+	server.Restore([]ghoma.DeviceInfo{{ID: "aaaaaa", FirmwareVersion: "1.1.7"}})
+	for _, firmware := range []string{"01 01 06", "01 01 06", "01 01 07"} {
+		_, err := ghomatest.Replay(server, session(firmware), ghomatest.Options{})
+		require.NoError(t, err)
+	}
+
+	inventory := server.Inventory()
+	require.Len(t, inventory, 2)
+	require.False(t, inventory[0].FirmwareChanged)
+	require.False(t, inventory[0].FirmwareDrift)
+
+	plug := inventory[1]
+	require.Equal(t, "d78a1c", plug.ID)
+	require.False(t, plug.Online)
+	require.Equal(t, "1.1.7", plug.FirmwareVersion)
+	require.Len(t, plug.Firmwares, 2)
+	require.Equal(t, "1.1.6", plug.Firmwares[0].Version)
+	require.Equal(t, "1.1.7", plug.Firmwares[1].Version)
+	require.True(t, plug.FirstSeen.Before(plug.Firmwares[1].Since))
+	require.True(t, plug.FirmwareChanged)
+	require.False(t, plug.FirmwareDrift)
+
+	require.NoError(t, testutil.CollectAndCompare(server, strings.NewReader(`
+# HELP ghoma_device_firmware_drift whether the device runs another firmware than the expected one (0 = expected, 1 = other)
+# TYPE ghoma_device_firmware_drift gauge
+ghoma_device_firmware_drift{device="aaaaaa"} 0
+ghoma_device_firmware_drift{device="d78a1c"} 0
+# HELP ghoma_device_info firmware of every device that registered, always 1
+# TYPE ghoma_device_info gauge
+ghoma_device_info{device="aaaaaa",firmware="1.1.7"} 1
+ghoma_device_info{device="d78a1c",firmware="1.1.7"} 1
+`), "ghoma_device_info", "ghoma_device_firmware_drift"))
+
+	_, err := ghomatest.Replay(server, session("01 01 06"), ghomatest.Options{})
+	require.NoError(t, err)
+	require.True(t, server.Inventory()[1].FirmwareDrift)
+}
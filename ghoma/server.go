@@ -0,0 +1,480 @@
+package ghoma
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+// StatusHandler receives every status message reported by a device.
+// HandleStatus is called from the device goroutine and must not block.
+type StatusHandler interface {
+	HandleStatus(*Device, protocol.Message)
+}
+
+type ServerOptions struct {
+	ListenAddr string
+	// Logger defaults to zap.L().
+	Logger *zap.Logger
+
+	// CommandTimeout is how long to wait for a device to acknowledge a
+	// command before sending it again, up to CommandRetries times.
+	CommandTimeout time.Duration
+	CommandRetries int
+
+	// AllowedNetworks restricts the clients accepted, DeniedNetworks are
+	// always rejected.
+	AllowedNetworks []netip.Prefix
+	DeniedNetworks  []netip.Prefix
+	// AllowedDevices restricts the device IDs registered, checked once the
+	// device reported its ID in the INIT1 reply.
+	AllowedDevices []string
+	// MaxConnections and MaxConnectionsPerIP cap the connections served
+	// concurrently, zero means unlimited.
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	// HandshakeTimeout bounds the time a client has to register.
+	HandshakeTimeout time.Duration
+	// AcceptRate limits the connections accepted per second, with bursts of
+	// up to AcceptBurst, zero means unlimited.
+	AcceptRate  float64
+	AcceptBurst int
+	// Trace records the frames exchanged with devices to files.
+	Trace TraceOptions
+	// MaxUnknownFrames caps the distinct undecoded payloads kept, see
+	// Server.UnknownFrames, 256 when zero.
+	MaxUnknownFrames int
+	// ExpectedFirmware is the firmware version every device should run,
+	// devices running another one are flagged in the inventory.
+	ExpectedFirmware string
+	// ShutdownTimeout bounds the shutdown started once the context given to
+	// Start is done, 10s when zero.
+	ShutdownTimeout time.Duration
+}
+
+type Server struct {
+	listenerMu sync.Mutex
+	listener   net.Listener
+	quit       chan interface{}
+	stopOnce   sync.Once
+	stopped    chan struct{}
+	wg         sync.WaitGroup
+
+	devices      sync.Map
+	devicesCount atomic.Uint64
+
+	options       ServerOptions
+	handlers      []StatusHandler
+	eventHandlers []EventHandler
+	metrics       *serverMetrics
+	admission     *admission
+	tracer        *tracer
+	unknown       *unknownFrames
+
+	knownMu sync.Mutex
+	known   map[string]DeviceInfo
+	// switches is the switch state of every outlet of a device when its
+	// last session ended, see Device.lastState.
+	switches map[string]map[int]bool
+}
+
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		quit:     make(chan interface{}),
+		stopped:  make(chan struct{}),
+		metrics:  newServerMetrics(),
+		known:    make(map[string]DeviceInfo),
+		switches: make(map[string]map[int]bool),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.options.Logger == nil {
+		s.options.Logger = zap.L()
+	}
+	if s.options.CommandTimeout <= 0 {
+		s.options.CommandTimeout = 5 * time.Second
+	}
+	if s.options.CommandRetries < 0 {
+		s.options.CommandRetries = 0
+	}
+	if s.options.HandshakeTimeout <= 0 {
+		s.options.HandshakeTimeout = 10 * time.Second
+	}
+	if s.options.MaxUnknownFrames <= 0 {
+		s.options.MaxUnknownFrames = 256
+	}
+	if s.options.ShutdownTimeout <= 0 {
+		s.options.ShutdownTimeout = 10 * time.Second
+	}
+	s.admission = newAdmission(&s.options)
+	s.tracer = newTracer(&s.options.Trace, s.options.Logger)
+	s.unknown = newUnknownFrames(s.options.MaxUnknownFrames)
+	return s
+}
+
+// Listening reports whether the server is accepting device connections.
+func (s *Server) Listening() bool {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+	if s.listener == nil {
+		return false
+	}
+	select {
+	case <-s.quit:
+		return false
+	default:
+		return true
+	}
+}
+
+// Shutdown stops accepting connections, lets every device send what is
+// left in its outbound queue, then closes the device connections and waits
+// for their handlers to return or ctx to be done. Only the first call shuts
+// the server down, later ones wait for it.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+		s.listenerMu.Lock()
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		s.listenerMu.Unlock()
+		go s.stop(ctx)
+	})
+
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop drains then closes every device connection and waits for the
+// handlers, the tracer is closed last.
+func (s *Server) stop(ctx context.Context) {
+	defer close(s.stopped)
+	defer s.tracer.close()
+
+	var wg sync.WaitGroup
+	for _, dev := range s.Devices() {
+		wg.Add(1)
+		go func(dev *Device) {
+			defer wg.Done()
+			if err := dev.drain(ctx); err != nil && !errors.Is(err, ErrDeviceOffline) {
+				dev.logger.Warn("unable to flush outbound queue", zap.Error(err))
+			}
+			dev.close()
+			dev.conn.Close()
+		}(dev)
+	}
+	wg.Wait()
+	s.wg.Wait()
+}
+
+func (s *Server) Start(ctx context.Context) (err error) {
+	logger := s.options.Logger
+	listener, err := net.Listen("tcp", s.options.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.listenerMu.Lock()
+	s.listener = listener
+	s.listenerMu.Unlock()
+	s.wg.Add(1)
+	logger.Info("Ghoma server listening", zap.String("address", listener.Addr().String()))
+
+	go func() {
+		<-ctx.Done()
+		logger.Info("Stopping server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.options.ShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			logger.Error("unable to shut down server", zap.Error(err))
+		}
+	}()
+
+	go s.serve(listener)
+	return nil
+}
+
+// ServeConn serves a device connection accepted outside of the server, such
+// as one end of a net.Pipe, and returns once the connection is closed. Only
+// connections accepted by Start go through admission.
+func (s *Server) ServeConn(c net.Conn) {
+	s.wg.Add(1)
+	defer s.wg.Done()
+	s.metrics.connections.Inc()
+	s.handleDevice(c)
+}
+
+func (s *Server) serve(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		c, err := listener.Accept()
+		logger := s.options.Logger
+		if c != nil {
+			logger = logger.With(zap.String("remote", c.RemoteAddr().String()))
+		}
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+				logger.Error("error accepting new connection", zap.Error(err))
+				continue
+			}
+		}
+
+		s.metrics.connections.Inc()
+		release, reason := s.admission.admit(c.RemoteAddr())
+		if release == nil {
+			s.reject(logger, c, reason)
+			continue
+		}
+
+		s.wg.Add(1)
+		go func(c net.Conn, logger *zap.Logger) {
+			defer s.wg.Done()
+			defer release()
+			logger.Info("Device connected")
+			s.handleDevice(c)
+		}(c, logger)
+	}
+}
+
+func (s *Server) handleDevice(c net.Conn) {
+	defer c.Close()
+	logger := s.options.Logger.With(zap.String("remote_address", c.RemoteAddr().String()))
+
+	_ = c.SetDeadline(time.Now().Add(s.options.HandshakeTimeout))
+	dev, err := s.register(logger, c)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDeviceNotAllowed):
+			s.reject(logger.With(zap.Error(err)), c, rejectDevice)
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			s.reject(logger.With(zap.Error(err)), c, rejectHandshakeTimeout)
+		default:
+			logger.Error("unable to register Device", zap.Error(err))
+		}
+		return
+	}
+	_ = c.SetDeadline(time.Time{})
+
+	logger = logger.With(zap.String(deviceField, dev.ID))
+	dev.logger = logger
+
+	go dev.run()
+	defer func() {
+		dev.close()
+		// A session taken over by a reconnection is not a disconnection
+		if s.unregister(dev) {
+			s.seen(dev)
+			s.emit(EventDisconnected, dev, nil)
+		}
+	}()
+	s.emit(EventConnected, dev, nil)
+
+	logger.Info("Device registered", zap.String("firmware_version", dev.FirmwareVersion), zap.Uint64("devices_connected", s.devicesCount.Load()))
+
+	for {
+		msg, err := dev.read()
+		if err != nil {
+			if msg != nil {
+				logger = logger.With(zap.Any("msg", msg))
+			}
+			if errors.Is(err, protocol.ErrCmdUnknown) {
+				s.metrics.messages.WithLabelValues(dev.ID, protocol.Command(0).String()).Inc()
+				s.captureUnknown(dev, UnknownCommand, msg)
+				logger.Debug("unknown command")
+				continue
+			}
+			select {
+			case <-dev.closed:
+				logger.Info("Device session closed")
+				return
+			default:
+			}
+			if errors.Is(err, syscall.ECONNRESET) {
+				logger.Info("Device disconnected")
+				return
+			} else {
+				logger.Error("read error", zap.Error(err))
+			}
+			return
+		}
+		s.metrics.messages.WithLabelValues(dev.ID, msg.Command.String()).Inc()
+		dev.attribute(msg)
+		s.emit(EventMessage, dev, msg)
+		s.handle(dev, msg)
+	}
+}
+
+// reject closes a connection that is not admitted, counting it by reason.
+func (s *Server) reject(logger *zap.Logger, c net.Conn, reason string) {
+	s.metrics.rejections.WithLabelValues(reason).Inc()
+	logger.Warn("Connection rejected", zap.String("reason", reason))
+	c.Close()
+}
+
+// register runs the handshake, failures are counted by the stage they
+// happened at.
+func (s *Server) register(logger *zap.Logger, c net.Conn) (dev *Device, err error) {
+	dev = newDevice(logger, &s.options, s.metrics, c)
+	dev.tracer = s.tracer
+
+	stage := "init1"
+	defer func() {
+		if err != nil && !errors.Is(err, ErrDeviceNotAllowed) {
+			s.metrics.handshakeFailures.WithLabelValues(stage).Inc()
+		}
+	}()
+
+	if err := dev.write(*protocol.MustParse(protocol.Init1)); err != nil {
+		return nil, err
+	}
+
+	msg, err := dev.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.Payload) < 9 {
+		return nil, fmt.Errorf("%w: init1 reply of %d bytes", ErrHandshake, len(msg.Payload))
+	}
+
+	dev.triggerCode = msg.Payload[4:6]
+	dev.shortMac = msg.Payload[6:9]
+	dev.ID = hex.EncodeToString(dev.shortMac)
+	if err := s.admission.allowedDevice(dev.ID); err != nil {
+		return nil, err
+	}
+
+	stage = "init1_ack"
+	if err := dev.write(*protocol.MustParse(protocol.Init1ACK)); err != nil {
+		return nil, err
+	}
+
+	stage = "init2"
+	if err := dev.write(*protocol.MustParse(protocol.Init2)); err != nil {
+		return nil, err
+	}
+
+	_, err = dev.read()
+	if err != nil {
+		return nil, err
+	}
+
+	stage = "firmware"
+	msg, err = dev.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.Payload) < 3 {
+		return nil, fmt.Errorf("%w: firmware message of %d bytes", ErrHandshake, len(msg.Payload))
+	}
+
+	dev.FirmwareVersion = fmt.Sprintf(
+		"%d.%d.%d",
+		msg.Payload[len(msg.Payload)-3],
+		msg.Payload[len(msg.Payload)-2],
+		msg.Payload[len(msg.Payload)-1],
+	)
+
+	s.takeover(logger, dev)
+	if previous := s.remember(dev); previous != "" && previous != dev.FirmwareVersion {
+		logger.Warn("Device firmware changed",
+			zap.String("device_id", dev.ID),
+			zap.String("previous_firmware_version", previous),
+			zap.String("firmware_version", dev.FirmwareVersion),
+		)
+	}
+
+	return dev, nil
+}
+
+// takeover stores the device, closing the session already registered with
+// the same ID when the plug reconnected before the old one died. The device
+// starts from the switch states of the closed session.
+func (s *Server) takeover(logger *zap.Logger, dev *Device) {
+	previous, loaded := s.devices.Swap(dev.ID, dev)
+	if !loaded {
+		s.devicesCount.Add(1)
+		return
+	}
+
+	old := previous.(*Device)
+	logger.Warn("Device reconnected, closing previous session",
+		zap.String("device_id", dev.ID),
+		zap.String("previous_remote_address", old.RemoteAddr()),
+	)
+	s.metrics.reconnects.WithLabelValues(dev.ID).Inc()
+	old.close()
+	old.conn.Close()
+	dev.previous = old.switches()
+}
+
+// unregister removes the device unless it has been taken over by a newer
+// session, it returns whether the device was removed.
+func (s *Server) unregister(dev *Device) bool {
+	if s.devices.CompareAndDelete(dev.ID, dev) {
+		s.devicesCount.Add(^uint64(0))
+		return true
+	}
+	return false
+}
+
+// Device returns the connected device registered with the given ID.
+func (s *Server) Device(id string) (*Device, bool) {
+	dev, exist := s.devices.Load(id)
+	if !exist {
+		return nil, false
+	}
+	return dev.(*Device), true
+}
+
+// Devices returns every connected device, sorted by ID.
+func (s *Server) Devices() []*Device {
+	var devices []*Device
+	s.devices.Range(func(_, dev any) bool {
+		devices = append(devices, dev.(*Device))
+		return true
+	})
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+	return devices
+}
+
+func (s *Server) handle(dev *Device, msg *protocol.Message) {
+	switch msg.Command {
+	case protocol.CmdHeartBeat:
+		dev.post(*protocol.MustParse(protocol.HeartBeatReply))
+	case protocol.CmdStatus:
+		if msg.Status.Unknown {
+			s.captureUnknown(dev, UnknownStatus, msg)
+			dev.logger.Debug("unknown status", zap.Any("msg", msg))
+		}
+		dev.update(msg)
+		dev.acknowledge(msg)
+		for _, h := range s.handlers {
+			h.HandleStatus(dev, *msg)
+		}
+	}
+}
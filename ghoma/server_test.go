@@ -0,0 +1,243 @@
+package ghoma
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/protocol"
+	"github.com/eliecharra/ghoma/trace"
+)
+
+var (
+	init1Reply  = []byte{0x03, 0x01, 0x0a, 0xc0, 0x32, 0x23, 0xd7, 0x8a, 0x1c, 0x01, 0x00}
+	init2Reply  = []byte{0x07, 0x01}
+	firmwareMsg = []byte{0x07, 0x01, 0x0a, 0xe0, 0x32, 0x23, 0xd7, 0x8a, 0x1c, 0x01, 0x01, 0x06}
+)
+
+// plug reads a message for every step and answers it with the step frames,
+// then closes the connection.
+func plug(t *testing.T, c net.Conn, steps ...[][]byte) {
+	defer c.Close()
+	for _, frames := range steps {
+		if _, err := protocol.ReadMessage(c); err != nil {
+			t.Error(err)
+			return
+		}
+		for _, frame := range frames {
+			if _, err := c.Write(protocol.Message{Payload: frame}.ToBytes()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}
+}
+
+func TestServer_Register(t *testing.T) {
+	server, client := net.Pipe()
+	s := NewServer()
+	go plug(t, client, [][]byte{init1Reply}, nil, [][]byte{init2Reply, firmwareMsg})
+
+	dev, err := s.register(zap.NewNop(), server)
+	server.Close()
+	require.NoError(t, err)
+	require.Equal(t, "d78a1c", dev.ID)
+	require.Equal(t, "1.1.6", dev.FirmwareVersion)
+	require.Equal(t, uint64(1), s.devicesCount.Load())
+	require.Equal(t, 0, testutil.CollectAndCount(s.metrics.handshakeFailures))
+	require.Greater(t, testutil.ToFloat64(s.metrics.bytes.WithLabelValues("received")), float64(0))
+	require.Greater(t, testutil.ToFloat64(s.metrics.bytes.WithLabelValues("sent")), float64(0))
+}
+
+func TestServer_Register_Failures(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps [][][]byte
+		stage string
+	}{
+		{
+			name:  "short init1 reply",
+			steps: [][][]byte{{{0x03, 0x01, 0x0a}}},
+			stage: "init1",
+		},
+		{
+			name:  "no init2 reply",
+			steps: [][][]byte{{init1Reply}, nil, nil},
+			stage: "init2",
+		},
+		{
+			name:  "no firmware",
+			steps: [][][]byte{{init1Reply}, nil, {init2Reply}},
+			stage: "firmware",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			s := NewServer()
+			go plug(t, client, tt.steps...)
+
+			_, err := s.register(zap.NewNop(), server)
+			server.Close()
+			require.Error(t, err)
+			require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.handshakeFailures.WithLabelValues(tt.stage)))
+			require.Equal(t, uint64(0), s.devicesCount.Load())
+		})
+	}
+}
+
+func TestServer_Register_Takeover(t *testing.T) {
+	s := NewServer()
+	handshake := [][][]byte{{init1Reply}, nil, {init2Reply, firmwareMsg}}
+
+	register := func() *Device {
+		server, client := net.Pipe()
+		t.Cleanup(func() { server.Close() })
+		go plug(t, client, handshake...)
+		dev, err := s.register(zap.NewNop(), server)
+		require.NoError(t, err)
+		return dev
+	}
+
+	old := register()
+	old.update(switchStatus(true))
+	dev := register()
+	require.Equal(t, map[int]bool{1: true}, dev.previous, "the switch states of the previous session are kept")
+
+	require.Equal(t, uint64(1), s.devicesCount.Load())
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.reconnects.WithLabelValues("d78a1c")))
+	current, exist := s.Device("d78a1c")
+	require.True(t, exist)
+	require.Same(t, dev, current)
+
+	select {
+	case <-old.closed:
+	default:
+		t.Fatal("previous session should be closed")
+	}
+	_, err := old.read()
+	require.Error(t, err, "previous connection should be closed")
+
+	require.False(t, s.unregister(old), "previous session must not unregister the device")
+	require.Equal(t, uint64(1), s.devicesCount.Load())
+	require.True(t, s.unregister(dev))
+	require.Equal(t, uint64(0), s.devicesCount.Load())
+
+	dev.update(switchStatus(false))
+	s.seen(dev)
+	require.Equal(t, map[int]bool{1: false}, register().previous, "the switch states of the last session are kept")
+}
+
+func TestServer_Register_DeviceNotAllowed(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	s := NewServer(WithServerOptions(ServerOptions{AllowedDevices: []string{"0a0b0c"}}))
+	go plug(t, client, [][]byte{init1Reply})
+
+	_, err := s.register(zap.NewNop(), server)
+	require.ErrorIs(t, err, ErrDeviceNotAllowed)
+	require.Equal(t, 0, testutil.CollectAndCount(s.metrics.handshakeFailures))
+	require.Equal(t, uint64(0), s.devicesCount.Load())
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	s := NewServer(WithListenAddr("127.0.0.1:0"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.Start(ctx))
+
+	client, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	reader := bufio.NewReader(client)
+	reply := func(frames ...[]byte) {
+		_, err := protocol.ReadMessage(reader)
+		require.NoError(t, err)
+		for _, frame := range frames {
+			_, err := client.Write(protocol.Message{Payload: frame}.ToBytes())
+			require.NoError(t, err)
+		}
+	}
+	reply(init1Reply)
+	reply()
+	reply(init2Reply, firmwareMsg)
+
+	require.Eventually(t, func() bool {
+		_, exist := s.Device("d78a1c")
+		return exist
+	}, time.Second, 10*time.Millisecond)
+	dev, _ := s.Device("d78a1c")
+	dev.post(*protocol.MustParse(protocol.HeartBeatReply))
+
+	shutdownCtx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	require.NoError(t, s.Shutdown(shutdownCtx))
+	cancel()
+	require.NoError(t, s.Shutdown(shutdownCtx), "a second shutdown waits for the first one")
+	require.False(t, s.Listening())
+
+	msg, err := protocol.ReadMessage(reader)
+	require.NoError(t, err, "queued message is sent before closing")
+	require.Equal(t, protocol.CmdHeartBeatReply, msg.Command)
+	_, err = protocol.ReadMessage(reader)
+	require.Error(t, err, "connection is closed")
+
+	require.Empty(t, s.Devices())
+	known := s.Known()
+	require.Len(t, known, 1)
+	require.True(t, known[0].LastSeen.After(dev.connectedAt), "disconnection is the last time the device was seen")
+	known[0].LastSeen = time.Time{}
+	require.Equal(t, DeviceInfo{
+		ID:              "d78a1c",
+		FirmwareVersion: "1.1.6",
+		RemoteAddress:   client.LocalAddr().String(),
+		ConnectedAt:     dev.connectedAt,
+		FirstSeen:       dev.connectedAt,
+		Firmwares:       []Firmware{{Version: "1.1.6", Since: dev.connectedAt}},
+	}, known[0])
+}
+
+func TestServer_Trace(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(WithServerOptions(ServerOptions{Trace: TraceOptions{Dir: dir, Devices: []string{"D78A1C"}}}))
+	require.Equal(t, []string{"d78a1c"}, s.Traced())
+	require.ErrorIs(t, s.StartTrace("../d78a1c"), ErrInvalidDeviceID)
+
+	server, client := net.Pipe()
+	go plug(t, client, [][]byte{init1Reply}, nil, [][]byte{init2Reply, firmwareMsg}, nil)
+	dev, err := s.register(zap.NewNop(), server)
+	require.NoError(t, err)
+	require.NoError(t, dev.write(*protocol.MustParse(protocol.HeartBeatReply)))
+	server.Close()
+	require.NoError(t, s.StopTrace("d78a1c"))
+	require.Empty(t, s.Traced())
+
+	records, err := trace.ReadFile(filepath.Join(dir, "d78a1c.jsonl"))
+	require.NoError(t, err)
+	var frames []string
+	for _, r := range records {
+		require.Equal(t, "d78a1c", r.Device)
+		require.Empty(t, r.Error)
+		frames = append(frames, string(r.Direction)+" "+hex.EncodeToString(r.Frame))
+	}
+	frame := func(payload []byte) string {
+		return hex.EncodeToString(protocol.Message{Payload: payload}.ToBytes())
+	}
+	require.Equal(t, []string{
+		"out " + hex.EncodeToString(protocol.MustParse(protocol.Init1).ToBytes()),
+		"in " + frame(init1Reply),
+		"out " + hex.EncodeToString(protocol.MustParse(protocol.Init1ACK).ToBytes()),
+		"out " + hex.EncodeToString(protocol.MustParse(protocol.Init2).ToBytes()),
+		"in " + frame(init2Reply),
+		"in " + frame(firmwareMsg),
+		"out " + hex.EncodeToString(protocol.MustParse(protocol.HeartBeatReply).ToBytes()),
+	}, frames)
+}
@@ -0,0 +1,172 @@
+package ghoma
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/trace"
+)
+
+var (
+	ErrTracingDisabled = errors.New("wire tracing is disabled, no trace directory configured")
+	ErrInvalidDeviceID = errors.New("invalid device ID")
+)
+
+type TraceOptions struct {
+	// Dir holds a trace file per device, named after the device ID, tracing
+	// is disabled when empty.
+	Dir string
+	// Devices are traced from the start, others once StartTrace is called.
+	Devices []string
+	// MaxBytes is the size trace files are rotated at, keeping up to
+	// MaxFiles rotated files.
+	MaxBytes int64
+	MaxFiles int
+}
+
+// tracer writes the frames of the traced devices to their trace file.
+type tracer struct {
+	options *TraceOptions
+	logger  *zap.Logger
+
+	mu    sync.Mutex
+	files map[string]*trace.File
+}
+
+func newTracer(options *TraceOptions, logger *zap.Logger) *tracer {
+	t := &tracer{
+		options: options,
+		logger:  logger,
+		files:   make(map[string]*trace.File),
+	}
+	for _, id := range options.Devices {
+		if id == "" {
+			continue
+		}
+		if err := t.start(id); err != nil {
+			logger.Error("unable to start wire tracing", zap.String(deviceField, id), zap.Error(err))
+		}
+	}
+	return t
+}
+
+func (t *tracer) start(id string) error {
+	if t.options.Dir == "" {
+		return ErrTracingDisabled
+	}
+	id = strings.ToLower(id)
+	// The ID names the trace file
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 6 {
+		return fmt.Errorf("%w: %q", ErrInvalidDeviceID, id)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exist := t.files[id]; exist {
+		return nil
+	}
+	f, err := trace.Create(filepath.Join(t.options.Dir, id+".jsonl"), t.options.MaxBytes, t.options.MaxFiles)
+	if err != nil {
+		return err
+	}
+	t.files[id] = f
+	return nil
+}
+
+func (t *tracer) stop(id string) error {
+	id = strings.ToLower(id)
+	t.mu.Lock()
+	f, exist := t.files[id]
+	delete(t.files, id)
+	t.mu.Unlock()
+	if !exist {
+		return nil
+	}
+	return f.Close()
+}
+
+func (t *tracer) traced() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.files))
+	for id := range t.files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// write records frames of the device when it is traced.
+func (t *tracer) write(id string, records ...trace.Record) {
+	t.mu.Lock()
+	f, exist := t.files[id]
+	t.mu.Unlock()
+	if !exist {
+		return
+	}
+	if err := f.Write(records...); err != nil {
+		t.logger.Error("unable to write wire trace", zap.String(deviceField, id), zap.Error(err))
+	}
+}
+
+func (t *tracer) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, f := range t.files {
+		_ = f.Close()
+		delete(t.files, id)
+	}
+}
+
+// StartTrace records every frame exchanged with the device to its trace
+// file, from its next handshake when it is not connected.
+func (s *Server) StartTrace(id string) error {
+	return s.tracer.start(id)
+}
+
+func (s *Server) StopTrace(id string) error {
+	return s.tracer.stop(id)
+}
+
+// Traced returns the IDs of the traced devices.
+func (s *Server) Traced() []string {
+	return s.tracer.traced()
+}
+
+// record traces a frame read from or written to the device. Frames of the
+// handshake are kept until the device reported its ID.
+func (d *Device) record(direction trace.Direction, frame []byte, err error) {
+	if d.tracer == nil {
+		return
+	}
+	record := trace.Record{
+		Time:      time.Now(),
+		Device:    d.ID,
+		Direction: direction,
+		Frame:     frame,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if d.ID == "" {
+		d.handshake = append(d.handshake, record)
+		return
+	}
+	if d.handshake != nil {
+		records := append(d.handshake, record)
+		d.handshake = nil
+		for i := range records {
+			records[i].Device = d.ID
+		}
+		d.tracer.write(d.ID, records...)
+		return
+	}
+	d.tracer.write(d.ID, record)
+}
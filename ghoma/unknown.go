@@ -0,0 +1,110 @@
+package ghoma
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+// Kinds of unknown frames.
+const (
+	UnknownCommand = "command"
+	UnknownStatus  = "status"
+)
+
+// UnknownFrame is a payload the protocol package could not decode, kept to
+// reverse engineer new firmware.
+type UnknownFrame struct {
+	Device          string    `json:"device"`
+	FirmwareVersion string    `json:"firmware_version"`
+	Kind            string    `json:"kind"`
+	Payload         string    `json:"payload"`
+	Count           uint64    `json:"count"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+type unknownKey struct {
+	device  string
+	payload string
+}
+
+// unknownFrames keeps the distinct unknown payloads of every device, the
+// least recently seen is evicted once max is reached.
+type unknownFrames struct {
+	max int
+
+	mu     sync.Mutex
+	frames map[unknownKey]*UnknownFrame
+}
+
+func newUnknownFrames(max int) *unknownFrames {
+	return &unknownFrames{max: max, frames: make(map[unknownKey]*UnknownFrame)}
+}
+
+func (u *unknownFrames) add(dev *Device, kind string, msg *protocol.Message) {
+	now := time.Now()
+	key := unknownKey{device: dev.ID, payload: hex.EncodeToString(msg.Payload)}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if f, exist := u.frames[key]; exist {
+		f.Count++
+		f.LastSeen = now
+		return
+	}
+	if len(u.frames) >= u.max {
+		u.evict()
+	}
+	u.frames[key] = &UnknownFrame{
+		Device:          dev.ID,
+		FirmwareVersion: dev.FirmwareVersion,
+		Kind:            kind,
+		Payload:         key.payload,
+		Count:           1,
+		FirstSeen:       now,
+		LastSeen:        now,
+	}
+}
+
+func (u *unknownFrames) evict() {
+	var oldest *UnknownFrame
+	var oldestKey unknownKey
+	for key, f := range u.frames {
+		if oldest == nil || f.LastSeen.Before(oldest.LastSeen) {
+			oldest, oldestKey = f, key
+		}
+	}
+	delete(u.frames, oldestKey)
+}
+
+func (u *unknownFrames) list() []UnknownFrame {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	frames := make([]UnknownFrame, 0, len(u.frames))
+	for _, f := range u.frames {
+		frames = append(frames, *f)
+	}
+	sort.Slice(frames, func(i, j int) bool {
+		if frames[i].Device != frames[j].Device {
+			return frames[i].Device < frames[j].Device
+		}
+		return frames[i].Payload < frames[j].Payload
+	})
+	return frames
+}
+
+// UnknownFrames returns the payloads devices sent that could not be
+// decoded, sorted by device then payload.
+func (s *Server) UnknownFrames() []UnknownFrame {
+	return s.unknown.list()
+}
+
+// captureUnknown keeps msg when the protocol package could not decode it.
+func (s *Server) captureUnknown(dev *Device, kind string, msg *protocol.Message) {
+	s.metrics.unknownFrames.WithLabelValues(dev.ID, kind).Inc()
+	s.unknown.add(dev, kind, msg)
+}
@@ -0,0 +1,29 @@
+package ghoma
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+func TestUnknownFrames(t *testing.T) {
+	u := newUnknownFrames(2)
+	dev := &Device{ID: "d78a1c", FirmwareVersion: "1.1.6"}
+	frame := func(payload ...byte) *protocol.Message {
+		return &protocol.Message{Payload: payload}
+	}
+
+	u.add(dev, UnknownCommand, frame(0xee, 0x01))
+	u.add(dev, UnknownCommand, frame(0xee, 0x02))
+	u.add(dev, UnknownCommand, frame(0xee, 0x01))
+	u.add(dev, UnknownCommand, frame(0xee, 0x03))
+
+	frames := u.list()
+	require.Len(t, frames, 2, "the least recently seen frame is evicted")
+	require.Equal(t, "ee01", frames[0].Payload)
+	require.Equal(t, uint64(2), frames[0].Count)
+	require.Equal(t, "ee03", frames[1].Payload)
+	require.Equal(t, "1.1.6", frames[1].FirmwareVersion)
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/control"
+	"github.com/eliecharra/ghoma/internal/discovery"
+	"github.com/eliecharra/ghoma/internal/history"
+	"github.com/eliecharra/ghoma/internal/stream"
+)
+
+const Prefix = "/api/"
+
+type API struct {
+	*router
+	server     *ghoma.Server
+	controller *control.Controller
+	broker     *stream.Broker
+	history    *history.Store
+	discoverer *discovery.Discoverer
+}
+
+// New builds the API, discovery routes are only served when discoverer is
+// not nil.
+func New(server *ghoma.Server, controller *control.Controller, broker *stream.Broker, history *history.Store, discoverer *discovery.Discoverer, logger *zap.Logger) *API {
+	a := &API{
+		server:     server,
+		controller: controller,
+		broker:     broker,
+		history:    history,
+		discoverer: discoverer,
+		router:     &router{prefix: Prefix, logger: logger},
+	}
+
+	a.router.handle(http.MethodGet, "devices", a.listDevices)
+	a.router.handle(http.MethodPost, "devices/{id}/on", a.switchDevice(true))
+	a.router.handle(http.MethodPost, "devices/{id}/off", a.switchDevice(false))
+	a.router.handle(http.MethodPost, "devices/{id}/outlets/{outlet}/on", a.switchOutlet(true))
+	a.router.handle(http.MethodPost, "devices/{id}/outlets/{outlet}/off", a.switchOutlet(false))
+	a.router.handle(http.MethodGet, "devices/{id}/status", a.deviceStatus)
+	a.router.handle(http.MethodGet, "devices/{id}/history", a.deviceHistory)
+	a.router.handle(http.MethodPost, "devices/{id}/trace", a.startTrace)
+	a.router.handle(http.MethodDelete, "devices/{id}/trace", a.stopTrace)
+	a.router.handle(http.MethodGet, "traces", a.listTraces)
+	a.router.handle(http.MethodGet, "inventory", a.listInventory)
+	a.router.handle(http.MethodGet, "groups", a.listGroups)
+	a.router.handle(http.MethodPost, "groups/{name}/on", a.switchGroup(true))
+	a.router.handle(http.MethodPost, "groups/{name}/off", a.switchGroup(false))
+	a.router.handle(http.MethodGet, "scenes", a.listScenes)
+	a.router.handle(http.MethodPost, "scenes/{name}/apply", a.applyScene)
+	a.router.handle(http.MethodGet, "events", a.streamEvents)
+	a.router.handle(http.MethodGet, "events/ws", a.streamEventsWebSocket)
+	if discoverer != nil {
+		a.router.handle(http.MethodGet, "discovery", a.listDiscovered)
+		a.router.handle(http.MethodGet, "discovery/unregistered", a.listUnregistered)
+	}
+
+	return a
+}
+
+type device struct {
+	ID              string `json:"id"`
+	FirmwareVersion string `json:"firmware_version"`
+	RemoteAddress   string `json:"remote_address"`
+}
+
+func (a *API) listDevices(w http.ResponseWriter, _ *http.Request, _ params) {
+	devices := make([]device, 0)
+	for _, dev := range a.server.Devices() {
+		devices = append(devices, device{
+			ID:              dev.ID,
+			FirmwareVersion: dev.FirmwareVersion,
+			RemoteAddress:   dev.RemoteAddr(),
+		})
+	}
+	a.writeJSON(w, http.StatusOK, devices)
+}
+
+func (a *API) deviceStatus(w http.ResponseWriter, _ *http.Request, p params) {
+	dev, online := a.server.Device(p["id"])
+	if !online {
+		a.writeError(w, http.StatusNotFound, "device offline")
+		return
+	}
+	a.writeJSON(w, http.StatusOK, dev.Status())
+}
+
+func (a *API) listTraces(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.server.Traced())
+}
+
+func (a *API) startTrace(w http.ResponseWriter, _ *http.Request, p params) {
+	if err := a.server.StartTrace(p["id"]); err != nil {
+		a.writeTraceError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, a.server.Traced())
+}
+
+func (a *API) stopTrace(w http.ResponseWriter, _ *http.Request, p params) {
+	if err := a.server.StopTrace(p["id"]); err != nil {
+		a.writeTraceError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, a.server.Traced())
+}
+
+func (a *API) writeTraceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ghoma.ErrInvalidDeviceID) {
+		a.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if errors.Is(err, ghoma.ErrTracingDisabled) {
+		a.writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func (a *API) listInventory(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.server.Inventory())
+}
+
+func (a *API) listDiscovered(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.discoverer.Plugs())
+}
+
+func (a *API) listUnregistered(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.discoverer.Unregistered())
+}
+
+func (a *API) switchDevice(on bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, p params) {
+		a.writeJSON(w, http.StatusOK, a.controller.SwitchDevice(r.Context(), p["id"], on))
+	}
+}
+
+func (a *API) switchOutlet(on bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, p params) {
+		outlet, err := strconv.Atoi(p["outlet"])
+		if err != nil || outlet < 1 || outlet > 255 {
+			a.writeError(w, http.StatusBadRequest, "invalid outlet "+p["outlet"])
+			return
+		}
+		a.writeJSON(w, http.StatusOK, a.controller.SwitchOutlet(r.Context(), p["id"], outlet, on))
+	}
+}
+
+type deviceHistory struct {
+	Device string              `json:"device"`
+	Outlet int                 `json:"outlet"`
+	Kind   string              `json:"kind"`
+	From   time.Time           `json:"from"`
+	To     time.Time           `json:"to"`
+	Step   string              `json:"step"`
+	Points []history.Aggregate `json:"points"`
+}
+
+// deviceHistory answers ?kind=POWER&outlet=&from=&to=&step=, outlet defaults
+// to the first one, from and to are RFC 3339 dates or unix timestamps and
+// default to the last hour, step is a duration or a number of seconds and
+// defaults to one minute.
+func (a *API) deviceHistory(w http.ResponseWriter, r *http.Request, p params) {
+	query := r.URL.Query()
+	res := deviceHistory{
+		Device: p["id"],
+		Outlet: 1,
+		Kind:   strings.ToUpper(query.Get("kind")),
+		To:     time.Now(),
+	}
+	if res.Kind == "" {
+		res.Kind = "POWER"
+	}
+
+	var err error
+	if v := query.Get("outlet"); v != "" {
+		if res.Outlet, err = strconv.Atoi(v); err != nil || res.Outlet < 1 || res.Outlet > 255 {
+			a.writeError(w, http.StatusBadRequest, "invalid outlet "+v)
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if res.To, err = parseTime(v); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid to: "+err.Error())
+			return
+		}
+	}
+	res.From = res.To.Add(-time.Hour)
+	if v := query.Get("from"); v != "" {
+		if res.From, err = parseTime(v); err != nil {
+			a.writeError(w, http.StatusBadRequest, "invalid from: "+err.Error())
+			return
+		}
+	}
+	step := time.Minute
+	if v := query.Get("step"); v != "" {
+		if step, err = parseDuration(v); err != nil || step <= 0 {
+			a.writeError(w, http.StatusBadRequest, "invalid step")
+			return
+		}
+	}
+	res.Step = step.String()
+	if !res.From.Before(res.To) {
+		a.writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	res.Points, err = a.history.Query(res.Device, res.Outlet, res.Kind, res.From, res.To, step)
+	if errors.Is(err, history.ErrSeriesNotFound) {
+		a.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	a.writeJSON(w, http.StatusOK, res)
+}
+
+func parseTime(v string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseDuration(v string) (time.Duration, error) {
+	if s, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(s) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func (a *API) listGroups(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.controller.Groups())
+}
+
+func (a *API) switchGroup(on bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, p params) {
+		results, err := a.controller.SwitchGroup(r.Context(), p["name"], on)
+		if err != nil {
+			a.writeControlError(w, err)
+			return
+		}
+		a.writeJSON(w, http.StatusOK, results)
+	}
+}
+
+func (a *API) listScenes(w http.ResponseWriter, _ *http.Request, _ params) {
+	a.writeJSON(w, http.StatusOK, a.controller.Scenes())
+}
+
+func (a *API) applyScene(w http.ResponseWriter, r *http.Request, p params) {
+	results, err := a.controller.ApplyScene(r.Context(), p["name"])
+	if err != nil {
+		a.writeControlError(w, err)
+		return
+	}
+	a.writeJSON(w, http.StatusOK, results)
+}
+
+func (a *API) streamEvents(w http.ResponseWriter, r *http.Request, _ params) {
+	a.broker.ServeSSE(w, r)
+}
+
+func (a *API) streamEventsWebSocket(w http.ResponseWriter, r *http.Request, _ params) {
+	a.broker.ServeWebSocket(w, r, a.controller)
+}
+
+func (a *API) writeControlError(w http.ResponseWriter, err error) {
+	if errors.Is(err, control.ErrGroupNotFound) || errors.Is(err, control.ErrSceneNotFound) {
+		a.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	a.writeError(w, http.StatusInternalServerError, err.Error())
+}
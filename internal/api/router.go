@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+type params map[string]string
+
+type handlerFunc func(w http.ResponseWriter, r *http.Request, p params)
+
+type route struct {
+	method   string
+	segments []string
+	handler  handlerFunc
+}
+
+// router dispatches requests on method and path segments, path segments
+// wrapped in braces (e.g. "{id}") match anything and are passed as params.
+type router struct {
+	prefix string
+	routes []route
+	logger *zap.Logger
+}
+
+func (rt *router) handle(method, pattern string, handler handlerFunc) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: split(pattern),
+		handler:  handler,
+	})
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := split(strings.TrimPrefix(r.URL.Path, rt.prefix))
+
+	methodNotAllowed := false
+	for _, route := range rt.routes {
+		p, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			methodNotAllowed = true
+			continue
+		}
+		route.handler(w, r, p)
+		return
+	}
+
+	if methodNotAllowed {
+		rt.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	rt.writeError(w, http.StatusNotFound, "not found")
+}
+
+func (r route) match(segments []string) (params, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	p := params{}
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			p[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return p, true
+}
+
+func split(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func (rt *router) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		rt.logger.Error("unable to write response", zap.Error(err))
+	}
+}
+
+func (rt *router) writeError(w http.ResponseWriter, status int, msg string) {
+	rt.writeJSON(w, status, map[string]string{"error": msg})
+}
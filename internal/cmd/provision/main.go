@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/internal/intrumentation"
+	"github.com/eliecharra/ghoma/internal/intrumentation/config"
+	"github.com/eliecharra/ghoma/internal/provision"
+)
+
+// passwordEnv holds the Wi-Fi password when no password file is given, so
+// that it never shows up in the process list.
+const passwordEnv = "GHOMA_WIFI_PASSWORD"
+
+func main() {
+	conf, err := config.Get()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error reading config: %s", err)
+		os.Exit(1)
+	}
+	logger, _, err := intrumentation.InitLogger(conf)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "unable to init logger: %s", err)
+		os.Exit(1)
+	}
+
+	var (
+		plug      = flag.String("plug", "", "IP of the plug to provision, every plug discovered when empty")
+		broadcast = flag.String("broadcast", "255.255.255.255", "address discovery is broadcast to")
+		udpPort   = flag.Int("udp-port", provision.Port, "configuration port of the plugs")
+		host      = flag.String("host", "", "ghoma server host, the address of this host on the network of the plug when empty")
+		port      = flag.Int("port", 0, "ghoma server port, the port of ghoma_listen_address when zero")
+		ssid      = flag.String("ssid", "", "Wi-Fi network to join, left untouched when empty")
+		password  = flag.String("password", "", "removed, the password would be visible in the process list, use -password-file")
+		passFile  = flag.String("password-file", "", "file holding the Wi-Fi password, - reads it from stdin, "+passwordEnv+" is used when empty")
+		timeout   = flag.Duration("timeout", 2*time.Second, "time to wait for plugs to answer")
+		dryRun    = flag.Bool("dry-run", false, "read the plugs configuration without changing it")
+		list      = flag.Bool("list", false, "only list the plugs and the server they connect to")
+		noRestart = flag.Bool("no-restart", false, "do not restart plugs to apply the configuration")
+	)
+	flag.Parse()
+
+	if *password != "" {
+		logger.Fatal("-password is no longer supported, use -password-file or " + passwordEnv)
+	}
+	var wifiPassword string
+	if *ssid != "" {
+		wifiPassword, err = readPassword(*passFile)
+		if err != nil {
+			logger.Fatal("unable to read Wi-Fi password", zap.Error(err))
+		}
+	}
+
+	if *port == 0 {
+		_, p, err := net.SplitHostPort(conf.GhomaListenAddress)
+		if err == nil {
+			*port, err = strconv.Atoi(p)
+		}
+		if err != nil {
+			logger.Fatal("unable to read port of ghoma listen address", zap.String("address", conf.GhomaListenAddress), zap.Error(err))
+		}
+	}
+
+	options := provision.Options{
+		Broadcast: *broadcast,
+		Port:      *udpPort,
+		Timeout:   *timeout,
+		Retries:   2,
+		DryRun:    *dryRun || *list,
+		Logger:    logger,
+	}
+
+	ips := []string{*plug}
+	if *plug == "" {
+		plugs, err := provision.Discover(context.Background(), options)
+		if err != nil {
+			logger.Fatal("discovery failed", zap.Error(err))
+		}
+		if len(plugs) == 0 {
+			logger.Fatal("no plug answered the discovery")
+		}
+		ips = ips[:0]
+		for _, p := range plugs {
+			logger.Info("plug discovered", zap.String("ip", p.IP), zap.String("mac", p.MAC), zap.String("device_id", p.ID()))
+			ips = append(ips, p.IP)
+		}
+	}
+
+	failed := false
+	for _, ip := range ips {
+		if *list {
+			failed = !show(logger, ip, options) || failed
+			continue
+		}
+		res, err := provision.Provision(ip, provision.Request{
+			SSID:     *ssid,
+			Password: wifiPassword,
+			Host:     *host,
+			Port:     *port,
+			Restart:  !*noRestart,
+		}, options)
+		if err != nil {
+			logger.Error("unable to provision plug", zap.String("ip", ip), zap.Error(err))
+			failed = true
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s -> %s\tchanged=%t\n", res.Plug.ID(), ip, res.Previous.Address(), res.Server.Address(), res.Changed)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readPassword reads the Wi-Fi password from path, from stdin when path is
+// "-" or from passwordEnv when it is empty. Trailing newlines are dropped.
+func readPassword(path string) (string, error) {
+	var data []byte
+	var err error
+	switch path {
+	case "":
+		return os.Getenv(passwordEnv), nil
+	case "-":
+		data, err = io.ReadAll(os.Stdin)
+	default:
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func show(logger *zap.Logger, ip string, options provision.Options) bool {
+	s, err := provision.Open(ip, options)
+	if err != nil {
+		logger.Error("unable to reach plug", zap.String("ip", ip), zap.Error(err))
+		return false
+	}
+	defer s.Close()
+	server, err := s.Server()
+	if err != nil {
+		logger.Error("unable to read plug server", zap.String("ip", ip), zap.Error(err))
+		return false
+	}
+	fmt.Printf("%s\t%s\t%s\n", s.Plug.ID(), ip, server.Address())
+	return true
+}
@@ -4,19 +4,33 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
-	"github.com/eliecharra/ghoma/internal/ghoma"
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/api"
+	"github.com/eliecharra/ghoma/internal/control"
+	"github.com/eliecharra/ghoma/internal/dashboard"
+	"github.com/eliecharra/ghoma/internal/debug"
+	"github.com/eliecharra/ghoma/internal/discovery"
+	"github.com/eliecharra/ghoma/internal/health"
+	"github.com/eliecharra/ghoma/internal/history"
+	"github.com/eliecharra/ghoma/internal/influx"
 	"github.com/eliecharra/ghoma/internal/intrumentation"
 	"github.com/eliecharra/ghoma/internal/intrumentation/config"
 	"github.com/eliecharra/ghoma/internal/metrics"
+	"github.com/eliecharra/ghoma/internal/provision"
+	"github.com/eliecharra/ghoma/internal/remotewrite"
+	"github.com/eliecharra/ghoma/internal/snapshot"
+	"github.com/eliecharra/ghoma/internal/stream"
 )
 
 func main() {
@@ -26,57 +40,269 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := intrumentation.InitLogger(conf); err != nil {
+	logger, levels, err := intrumentation.InitLogger(conf)
+	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "unable to init logger: %s", err)
 		os.Exit(1)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		// TODO log interrupt
-		cancel()
-	}()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-	metricCollector := metrics.NewCollector()
+	metricCollector := metrics.NewCollector(metrics.Options{
+		Timestamps:         conf.MetricsTimestamps,
+		LegacyNames:        conf.MetricsLegacyNames,
+		Windows:            conf.MetricsWindows,
+		PowerBuckets:       conf.MetricsPowerBuckets,
+		CurrentBuckets:     conf.MetricsCurrentBuckets,
+		UnverifiedReadings: conf.MetricsUnverifiedReadings,
+		Logger:             logger,
+	})
 	registry := prometheus.NewRegistry()
 	if err := registry.Register(metricCollector); err != nil {
-		zap.L().Fatal("unable to register metrics collector", zap.Error(err))
+		logger.Fatal("unable to register metrics collector", zap.Error(err))
+	}
+
+	historyStore, err := history.NewStore(history.Options{
+		Dir:           conf.HistoryDir,
+		Retention:     conf.HistoryRetention,
+		Resolution:    conf.HistoryResolution,
+		FlushInterval: conf.HistoryFlushInterval,
+		Logger:        logger,
+	})
+	if err != nil {
+		logger.Fatal("unable to create history store", zap.Error(err))
+	}
+	// outputs flush what they buffered once ctx is cancelled on shutdown
+	var outputs sync.WaitGroup
+	run := func(output func(context.Context)) {
+		outputs.Add(1)
+		go func() {
+			defer outputs.Done()
+			output(ctx)
+		}()
 	}
+	run(historyStore.Run)
 
+	allowedNetworks, err := ghoma.ParseNetworks(conf.GhomaAllowedNetworks)
+	if err != nil {
+		logger.Fatal("invalid allowed networks", zap.Error(err))
+	}
+	deniedNetworks, err := ghoma.ParseNetworks(conf.GhomaDeniedNetworks)
+	if err != nil {
+		logger.Fatal("invalid denied networks", zap.Error(err))
+	}
+
+	broker := stream.NewBroker(logger)
 	ghomaServer := ghoma.NewServer(
-		ghoma.ServerOptions{ListenAddr: conf.GhomaListenAddress},
-		metricCollector,
+		ghoma.WithServerOptions(ghoma.ServerOptions{
+			AllowedNetworks:     allowedNetworks,
+			DeniedNetworks:      deniedNetworks,
+			AllowedDevices:      conf.GhomaAllowedDevices,
+			MaxConnections:      conf.GhomaMaxConnections,
+			MaxConnectionsPerIP: conf.GhomaMaxConnectionsPerIP,
+			AcceptRate:          conf.GhomaAcceptRate,
+			AcceptBurst:         conf.GhomaAcceptBurst,
+			Trace: ghoma.TraceOptions{
+				Dir:      conf.TraceDir,
+				Devices:  conf.TraceDevices,
+				MaxBytes: conf.TraceMaxBytes,
+				MaxFiles: conf.TraceMaxFiles,
+			},
+			MaxUnknownFrames: conf.UnknownFramesMax,
+			ExpectedFirmware: conf.FirmwareExpected,
+			ShutdownTimeout:  conf.ShutdownTimeout,
+		}),
+		ghoma.WithListenAddr(conf.GhomaListenAddress),
+		ghoma.WithLogger(logger),
+		ghoma.WithCommandTimeout(conf.CommandTimeout, conf.CommandRetries),
+		ghoma.WithHandshakeTimeout(conf.GhomaHandshakeTimeout),
+		ghoma.WithStatusHandler(metricCollector),
+		ghoma.WithStatusHandler(historyStore),
+		ghoma.WithEventHandler(broker),
 	)
+	if err := registry.Register(ghomaServer); err != nil {
+		logger.Fatal("unable to register ghoma server metrics", zap.Error(err))
+	}
+	dash := dashboard.New(ghomaServer, metricCollector, conf.Devices, logger)
+	ghomaServer.AddEventHandler(dash)
+
+	if conf.InfluxURL != "" {
+		influxWriter, err := influx.NewWriter(influx.Options{
+			URL:            conf.InfluxURL,
+			Org:            conf.InfluxOrg,
+			Bucket:         conf.InfluxBucket,
+			Token:          conf.InfluxToken,
+			BatchSize:      conf.InfluxBatchSize,
+			FlushInterval:  conf.InfluxFlushInterval,
+			MaxRetries:     conf.InfluxMaxRetries,
+			BufferDir:      conf.InfluxBufferDir,
+			BufferMaxBytes: conf.InfluxBufferMaxBytes,
+			Aliases:        conf.Aliases(),
+			Logger:         logger,
+		})
+		if err != nil {
+			logger.Fatal("unable to create influx writer", zap.Error(err))
+		}
+		run(influxWriter.Run)
+		ghomaServer.AddEventHandler(influxWriter)
+	}
+
+	if conf.RemoteWriteURL != "" {
+		remoteWriter, err := remotewrite.NewClient(metricCollector, remotewrite.Options{
+			URL:         conf.RemoteWriteURL,
+			Interval:    conf.RemoteWriteInterval,
+			Username:    conf.RemoteWriteUsername,
+			Password:    conf.RemoteWritePassword,
+			BearerToken: conf.RemoteWriteBearerToken,
+			MaxRetries:  conf.RemoteWriteMaxRetries,
+			WALDir:      conf.RemoteWriteWALDir,
+			WALMaxBytes: conf.RemoteWriteWALMaxBytes,
+			Logger:      logger,
+		})
+		if err != nil {
+			logger.Fatal("unable to create remote write client", zap.Error(err))
+		}
+		if err := registry.Register(remoteWriter); err != nil {
+			logger.Fatal("unable to register remote write metrics", zap.Error(err))
+		}
+		run(remoteWriter.Run)
+	}
+
+	var discoverer *discovery.Discoverer
+	if conf.DiscoveryEnabled {
+		discoverer = discovery.New(ghomaServer, discovery.Options{
+			Interval: conf.DiscoveryInterval,
+			Probe: provision.Options{
+				Broadcast: conf.DiscoveryBroadcast,
+				Timeout:   conf.DiscoveryTimeout,
+			},
+			Logger: logger,
+		})
+		if err := registry.Register(discoverer); err != nil {
+			logger.Fatal("unable to register discovery metrics", zap.Error(err))
+		}
+		discoverer.Start(ctx)
+	}
+
+	if conf.SnapshotFile != "" {
+		restore(logger, conf.SnapshotFile, ghomaServer, metricCollector, historyStore)
+		if conf.SnapshotInterval > 0 {
+			go func() {
+				ticker := time.NewTicker(conf.SnapshotInterval)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						save(logger, conf.SnapshotFile, ghomaServer, metricCollector, historyStore)
+					}
+				}
+			}()
+		}
+	}
+
 	if err := ghomaServer.Start(ctx); err != nil {
-		zap.L().Fatal("Unable to start ghoma server", zap.Error(err))
+		logger.Fatal("Unable to start ghoma server", zap.Error(err))
 	}
 
 	servermux := http.NewServeMux()
-	servermux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
-		ErrorHandling: promhttp.ContinueOnError,
-	}))
+	servermux.Handle(api.Prefix, api.New(ghomaServer, control.NewController(ghomaServer, conf), broker, historyStore, discoverer, logger))
+	servermux.Handle(dashboard.Prefix, dash)
+	servermux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, dashboard.Prefix, http.StatusFound)
+	})
+	servermux.Handle("/metrics", metricCollector.Handler(registry))
+	probes := health.New(ghomaServer, registry, health.Options{MinDevices: conf.ReadyMinDevices, Logger: logger})
+	servermux.HandleFunc(health.LivePath, probes.Live)
+	servermux.HandleFunc(health.ReadyPath, probes.Ready)
+	if conf.DebugEndpoints {
+		servermux.Handle(debug.DevicesPath, debug.Devices(ghomaServer, logger))
+		servermux.Handle(debug.LogLevelPath, levels)
+		servermux.Handle(debug.UnknownFramesPath, debug.UnknownFrames(ghomaServer, logger))
+	}
+	if conf.DebugPprof {
+		debug.RegisterPprof(servermux)
+	}
 	httpServer := &http.Server{
 		Addr:    conf.ListenAddress,
 		Handler: servermux,
 	}
-	zap.L().Info("Prometheus exporter listening", zap.String("address", conf.ListenAddress))
+	logger.Info("Prometheus exporter listening", zap.String("address", conf.ListenAddress))
 	go func() {
 		if err := httpServer.ListenAndServe(); err != nil {
 			if !errors.Is(err, http.ErrServerClosed) {
-				zap.L().Fatal("Unable to start exporter", zap.Error(err))
+				logger.Fatal("Unable to start exporter", zap.Error(err))
 			}
 		}
 	}()
 
-	<-ctx.Done()
-	if err := httpServer.Shutdown(ctx); err != nil {
-		if !errors.Is(err, http.ErrServerClosed) {
-			zap.L().Fatal("Server shutdown failed", zap.Error(err))
-			os.Exit(1)
+	sig := <-stop
+	logger.Info("Shutting down", zap.Stringer("signal", sig))
+	shutdownCtx, done := context.WithTimeout(context.Background(), conf.ShutdownTimeout)
+	defer done()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Exporter shutdown failed", zap.Error(err))
+	}
+	if err := ghomaServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Ghoma server shutdown failed", zap.Error(err))
+	}
+	cancel()
+	outputs.Wait()
+	if conf.SnapshotFile != "" {
+		save(logger, conf.SnapshotFile, ghomaServer, metricCollector, historyStore)
+	}
+}
+
+func restore(logger *zap.Logger, path string, server *ghoma.Server, collector *metrics.Collector, store *history.Store) {
+	s, err := snapshot.Read(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("unable to read snapshot", zap.String("path", path), zap.Error(err))
 		}
+		return
+	}
+	server.Restore(s.Devices)
+	if len(s.Collector) > 0 {
+		if err := collector.Restore(s.Collector); err != nil {
+			logger.Error("unable to restore metrics from snapshot", zap.Error(err))
+		}
+	}
+	if len(s.History) > 0 {
+		if err := store.Restore(s.History); err != nil {
+			logger.Error("unable to restore history from snapshot", zap.Error(err))
+		}
+	}
+	logger.Info("Snapshot restored", zap.String("path", path), zap.Time("time", s.Time), zap.Int("devices", len(s.Devices)))
+}
+
+func save(logger *zap.Logger, path string, server *ghoma.Server, collector *metrics.Collector, store *history.Store) {
+	state, err := collector.Snapshot()
+	if err != nil {
+		logger.Error("unable to snapshot metrics", zap.Error(err))
+	}
+	pending, err := store.Snapshot()
+	if err != nil {
+		logger.Error("unable to snapshot history", zap.Error(err))
+	}
+	s := &snapshot.Snapshot{
+		Time:      time.Now(),
+		Devices:   server.Known(),
+		Collector: state,
+		History:   pending,
+	}
+	if err := snapshot.Write(path, s); err != nil {
+		logger.Error("unable to write snapshot", zap.String("path", path), zap.Error(err))
+		return
 	}
+	logger.Info("Snapshot written", zap.String("path", path), zap.Int("devices", len(s.Devices)))
 }
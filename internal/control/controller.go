@@ -0,0 +1,199 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/intrumentation/config"
+)
+
+const (
+	ResultAcked    = "acked"
+	ResultTimedOut = "timed_out"
+	ResultOffline  = "offline"
+	ResultError    = "error"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrSceneNotFound = errors.New("scene not found")
+)
+
+type registry interface {
+	Device(id string) (*ghoma.Device, bool)
+}
+
+type Result struct {
+	Device string `json:"device"`
+	Alias  string `json:"alias,omitempty"`
+	Outlet int    `json:"outlet,omitempty"`
+	Switch string `json:"switch"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Controller struct {
+	registry registry
+
+	devices map[string]config.DeviceConfig
+	groups  map[string]config.GroupConfig
+	scenes  map[string]map[string]bool
+}
+
+func NewController(registry registry, conf *config.Config) *Controller {
+	return &Controller{
+		registry: registry,
+		devices:  conf.Devices,
+		groups:   conf.Groups,
+		scenes:   conf.Scenes,
+	}
+}
+
+// Groups returns the members of every configured group.
+func (c *Controller) Groups() map[string][]string {
+	groups := make(map[string][]string, len(c.groups))
+	for name, group := range c.groups {
+		groups[name] = c.members(group)
+	}
+	return groups
+}
+
+// Group returns the sorted device IDs belonging to the named group.
+func (c *Controller) Group(name string) ([]string, error) {
+	group, exist := c.groups[name]
+	if !exist {
+		return nil, ErrGroupNotFound
+	}
+	return c.members(group), nil
+}
+
+func (c *Controller) Scenes() map[string]map[string]bool {
+	return c.scenes
+}
+
+// SwitchGroup switches every device of the named group to the same state.
+func (c *Controller) SwitchGroup(ctx context.Context, name string, on bool) ([]Result, error) {
+	members, err := c.Group(name)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]bool, len(members))
+	for _, id := range members {
+		states[id] = on
+	}
+	return c.apply(ctx, states), nil
+}
+
+// ApplyScene switches every device of the named scene to its desired state.
+func (c *Controller) ApplyScene(ctx context.Context, name string) ([]Result, error) {
+	scene, exist := c.scenes[name]
+	if !exist {
+		return nil, ErrSceneNotFound
+	}
+	return c.apply(ctx, scene), nil
+}
+
+func (c *Controller) members(group config.GroupConfig) []string {
+	set := make(map[string]struct{})
+	for _, id := range group.Devices {
+		set[strings.ToLower(id)] = struct{}{}
+	}
+	if len(group.Selector) > 0 {
+		for id, dev := range c.devices {
+			if matches(dev.Labels, group.Selector) {
+				set[strings.ToLower(id)] = struct{}{}
+			}
+		}
+	}
+
+	members := make([]string, 0, len(set))
+	for id := range set {
+		members = append(members, id)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func matches(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// apply sends switch commands concurrently and reports one result per
+// device, sorted by device ID.
+func (c *Controller) apply(ctx context.Context, states map[string]bool) []Result {
+	results := make([]Result, 0, len(states))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for id, on := range states {
+		wg.Add(1)
+		go func(id string, on bool) {
+			defer wg.Done()
+			res := c.SwitchDevice(ctx, id, on)
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(id, on)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Device < results[j].Device
+	})
+	return results
+}
+
+// SwitchDevice switches a single device and reports the outcome.
+func (c *Controller) SwitchDevice(ctx context.Context, id string, on bool) Result {
+	return c.switchOutlet(ctx, id, 0, on)
+}
+
+// SwitchOutlet switches a single outlet of a device and reports the outcome.
+func (c *Controller) SwitchOutlet(ctx context.Context, id string, outlet int, on bool) Result {
+	return c.switchOutlet(ctx, id, outlet, on)
+}
+
+// switchOutlet switches the whole device when outlet is 0.
+func (c *Controller) switchOutlet(ctx context.Context, id string, outlet int, on bool) Result {
+	res := Result{
+		Device: id,
+		Alias:  c.devices[id].Alias,
+		Outlet: outlet,
+		Switch: "OFF",
+		Result: ResultAcked,
+	}
+	if on {
+		res.Switch = "ON"
+	}
+
+	dev, online := c.registry.Device(id)
+	if !online {
+		res.Result = ResultOffline
+		return res
+	}
+	var err error
+	if outlet == 0 {
+		err = dev.Switch(ctx, on)
+	} else {
+		err = dev.SwitchOutlet(ctx, outlet, on)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, ghoma.ErrDeviceOffline):
+		res.Result = ResultOffline
+	case errors.Is(err, ghoma.ErrCommandTimeout):
+		res.Result = ResultTimedOut
+	default:
+		res.Result = ResultError
+		res.Error = err.Error()
+	}
+	return res
+}
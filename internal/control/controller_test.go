@@ -0,0 +1,71 @@
+package control
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/intrumentation/config"
+)
+
+type offlineRegistry struct{}
+
+func (offlineRegistry) Device(string) (*ghoma.Device, bool) {
+	return nil, false
+}
+
+func TestController_Group(t *testing.T) {
+	conf := &config.Config{
+		Devices: map[string]config.DeviceConfig{
+			"aaaaaa": {Alias: "server-1", Labels: map[string]string{"rack": "a"}},
+			"bbbbbb": {Alias: "server-2", Labels: map[string]string{"rack": "a", "role": "nas"}},
+			"cccccc": {Alias: "desk", Labels: map[string]string{"rack": "b"}},
+		},
+		Groups: map[string]config.GroupConfig{
+			"rack-a":  {Selector: map[string]string{"rack": "a"}},
+			"nas":     {Devices: []string{"dddddd"}, Selector: map[string]string{"role": "nas"}},
+			"static":  {Devices: []string{"cccccc", "aaaaaa"}},
+			"nothing": {Selector: map[string]string{"rack": "z"}},
+			"mixed":   {Devices: []string{"BBBBBB"}, Selector: map[string]string{"role": "nas"}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		group         string
+		want          []string
+		expectedError error
+	}{
+		{name: "selector", group: "rack-a", want: []string{"aaaaaa", "bbbbbb"}},
+		{name: "static and selector", group: "nas", want: []string{"bbbbbb", "dddddd"}},
+		{name: "static", group: "static", want: []string{"aaaaaa", "cccccc"}},
+		{name: "empty", group: "nothing", want: []string{}},
+		{name: "case insensitive", group: "mixed", want: []string{"bbbbbb"}},
+		{name: "unknown group", group: "missing", expectedError: ErrGroupNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewController(offlineRegistry{}, conf).Group(tt.group)
+			require.ErrorIs(t, err, tt.expectedError)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestController_ApplyScene_Offline(t *testing.T) {
+	conf := &config.Config{
+		Devices: map[string]config.DeviceConfig{"aaaaaa": {Alias: "server-1"}},
+		Scenes: map[string]map[string]bool{
+			"night": {"aaaaaa": false, "bbbbbb": true},
+		},
+	}
+
+	got, err := NewController(offlineRegistry{}, conf).ApplyScene(context.Background(), "night")
+	require.NoError(t, err)
+	require.Equal(t, []Result{
+		{Device: "aaaaaa", Alias: "server-1", Switch: "OFF", Result: ResultOffline},
+		{Device: "bbbbbb", Switch: "ON", Result: ResultOffline},
+	}, got)
+}
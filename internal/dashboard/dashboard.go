@@ -0,0 +1,184 @@
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/intrumentation/config"
+	"github.com/eliecharra/ghoma/internal/metrics"
+)
+
+const (
+	Prefix = "/dashboard/"
+
+	historyWindow = time.Hour
+	historyStep   = 30 * time.Second
+)
+
+//go:embed static
+var static embed.FS
+
+// Dashboard serves a single page overview of every known plug, built from
+// the device registry, the latest collector readings and the last hour of
+// power kept in memory.
+type Dashboard struct {
+	server    *ghoma.Server
+	collector *metrics.Collector
+	devices   map[string]config.DeviceConfig
+	files     http.Handler
+	logger    *zap.Logger
+
+	mu    sync.RWMutex
+	power map[outletKey]*ring
+}
+
+type outletKey struct {
+	device string
+	outlet int
+}
+
+func New(server *ghoma.Server, collector *metrics.Collector, devices map[string]config.DeviceConfig, logger *zap.Logger) *Dashboard {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return &Dashboard{
+		server:    server,
+		collector: collector,
+		devices:   devices,
+		files:     http.StripPrefix(Prefix, http.FileServer(http.FS(files))),
+		logger:    logger,
+		power:     make(map[outletKey]*ring),
+	}
+}
+
+func (d *Dashboard) HandleEvent(e ghoma.Event) {
+	if e.Message == nil || e.Message.Status == nil || e.Message.Status.Energy == nil {
+		return
+	}
+	if e.Message.Status.Energy.Kind() != "POWER" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := outletKey{device: e.Device, outlet: e.Message.Status.Outlet}
+	r, exist := d.power[key]
+	if !exist {
+		r = newRing(historyWindow, historyStep)
+		d.power[key] = r
+	}
+	r.add(e.Time, e.Message.Status.Energy.Measurement().Value)
+}
+
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, Prefix) == "devices.json" {
+		d.serveDevices(w)
+		return
+	}
+	d.files.ServeHTTP(w, r)
+}
+
+// device is a row of the dashboard, one per outlet.
+type device struct {
+	ID              string     `json:"id"`
+	Outlet          int        `json:"outlet"`
+	Alias           string     `json:"alias,omitempty"`
+	Online          bool       `json:"online"`
+	FirmwareVersion string     `json:"firmware_version,omitempty"`
+	Switch          string     `json:"switch,omitempty"`
+	Power           float64    `json:"power"`
+	Voltage         float64    `json:"voltage"`
+	Current         float64    `json:"current"`
+	LastContact     *time.Time `json:"last_contact,omitempty"`
+	PowerHistory    []point    `json:"power_history"`
+}
+
+func (d *Dashboard) serveDevices(w http.ResponseWriter) {
+	devices := make(map[string]*device)
+	get := func(id string) *device {
+		if _, exist := devices[id]; !exist {
+			devices[id] = &device{ID: id, Alias: d.devices[id].Alias}
+		}
+		return devices[id]
+	}
+
+	for id := range d.devices {
+		get(id)
+	}
+	for _, info := range d.server.Known() {
+		get(info.ID).FirmwareVersion = info.FirmwareVersion
+	}
+	for _, dev := range d.server.Devices() {
+		res := get(dev.ID)
+		res.Online = true
+		res.FirmwareVersion = dev.FirmwareVersion
+	}
+
+	since := time.Now().Add(-historyWindow)
+	history := make(map[outletKey][]point)
+	d.mu.RLock()
+	for key, r := range d.power {
+		get(key.device)
+		history[key] = r.since(since)
+	}
+	d.mu.RUnlock()
+
+	list := make([]*device, 0, len(devices))
+	for id, dev := range devices {
+		// every outlet with readings or power history gets a row, a device
+		// without any still gets one for its first outlet
+		outlets := d.collector.Outlets(id)
+		for key := range history {
+			if key.device == id && !slices.Contains(outlets, key.outlet) {
+				outlets = append(outlets, key.outlet)
+			}
+		}
+		if len(outlets) == 0 {
+			outlets = []int{1}
+		}
+
+		for _, outlet := range outlets {
+			res := *dev
+			res.Outlet = outlet
+			if readings, exist := d.collector.OutletReadings(id, outlet); exist {
+				if readings.Switch != nil {
+					res.Switch = "OFF"
+					if *readings.Switch {
+						res.Switch = "ON"
+					}
+				}
+				res.Power = readings.Power
+				res.Voltage = readings.Voltage
+				res.Current = readings.Current
+				res.LastContact = &readings.LastContact
+			}
+			res.PowerHistory = history[outletKey{device: id, outlet: outlet}]
+			if res.PowerHistory == nil {
+				res.PowerHistory = []point{}
+			}
+			list = append(list, &res)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ID != list[j].ID {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].Outlet < list[j].Outlet
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		d.logger.Error("unable to write dashboard devices", zap.Error(err))
+	}
+}
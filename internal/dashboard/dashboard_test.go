@@ -0,0 +1,49 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/metrics"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+func powerFrame(outlet, value byte) []byte {
+	return []byte{
+		0x90, 0x01, 0x0a, 0xe0, 0x32, 0x23, 0xd7, 0x8a, 0x1c,
+		0xff, 0xfe, 0x02, 0x81, 0x39, 0x00, 0x00, outlet,
+		0x01, 0x00, 0x00, 0x11, value,
+	}
+}
+
+func TestDashboard_Outlets(t *testing.T) {
+	collector := metrics.NewCollector(metrics.Options{})
+	d := New(ghoma.NewServer(ghoma.WithLogger(zap.NewNop())), collector, nil, zap.NewNop())
+
+	at := time.Now()
+	for outlet, value := range map[byte]byte{1: 0x34, 2: 0x35} {
+		msg := protocol.MustParse(powerFrame(outlet, value))
+		collector.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *msg)
+		d.HandleEvent(ghoma.Event{Kind: ghoma.EventMessage, Device: "d78a1c", Time: at, Message: msg})
+	}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest("GET", Prefix+"devices.json", nil))
+
+	var devices []device
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&devices))
+	require.Len(t, devices, 2)
+	for i, want := range []float64{44.04, 44.05} {
+		require.Equal(t, "d78a1c", devices[i].ID)
+		require.Equal(t, i+1, devices[i].Outlet)
+		require.Equal(t, want, devices[i].Power)
+		require.Len(t, devices[i].PowerHistory, 1)
+		require.Equal(t, want, devices[i].PowerHistory[0].Value)
+	}
+}
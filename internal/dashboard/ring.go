@@ -0,0 +1,66 @@
+package dashboard
+
+import (
+	"time"
+)
+
+// ring keeps the average of the values received in each step over a fixed
+// window, overwriting the oldest step once full.
+type ring struct {
+	step   time.Duration
+	points []point
+	next   int
+	full   bool
+}
+
+type point struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+	count int
+}
+
+func newRing(window, step time.Duration) *ring {
+	return &ring{
+		step:   step,
+		points: make([]point, window/step),
+	}
+}
+
+func (r *ring) add(t time.Time, v float64) {
+	bucket := t.Truncate(r.step)
+	if last := r.last(); last != nil && last.Time.Equal(bucket) {
+		last.Value += (v - last.Value) / float64(last.count+1)
+		last.count++
+		return
+	}
+
+	r.points[r.next] = point{Time: bucket, Value: v, count: 1}
+	r.next = (r.next + 1) % len(r.points)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) last() *point {
+	if !r.full && r.next == 0 {
+		return nil
+	}
+	return &r.points[(r.next+len(r.points)-1)%len(r.points)]
+}
+
+// since returns points newer than t, oldest first.
+func (r *ring) since(t time.Time) []point {
+	var points []point
+	if r.full {
+		points = append(points, r.points[r.next:]...)
+	}
+	points = append(points, r.points[:r.next]...)
+
+	res := make([]point, 0, len(points))
+	for _, p := range points {
+		if p.Time.After(t) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
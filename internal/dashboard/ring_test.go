@@ -0,0 +1,31 @@
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	start := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	r := newRing(4*time.Minute, time.Minute)
+
+	require.Empty(t, r.since(time.Time{}))
+
+	r.add(start, 10)
+	r.add(start.Add(20*time.Second), 20)
+	r.add(start.Add(40*time.Second), 30)
+	require.Equal(t, []point{{Time: start, Value: 20, count: 3}}, r.since(time.Time{}))
+
+	for i := 1; i <= 4; i++ {
+		r.add(start.Add(time.Duration(i)*time.Minute), float64(i))
+	}
+	require.Equal(t, []point{
+		{Time: start.Add(time.Minute), Value: 1, count: 1},
+		{Time: start.Add(2 * time.Minute), Value: 2, count: 1},
+		{Time: start.Add(3 * time.Minute), Value: 3, count: 1},
+		{Time: start.Add(4 * time.Minute), Value: 4, count: 1},
+	}, r.since(time.Time{}))
+	require.Len(t, r.since(start.Add(2*time.Minute)), 2)
+}
@@ -0,0 +1,120 @@
+"use strict";
+
+const api = "/api/";
+const tbody = document.getElementById("devices");
+
+function fmt(value, unit) {
+  return value.toFixed(2) + " " + unit;
+}
+
+function sparkline(points) {
+  const svg = document.createElementNS("http://www.w3.org/2000/svg", "svg");
+  svg.setAttribute("class", "sparkline");
+  svg.setAttribute("viewBox", "0 0 160 32");
+  svg.setAttribute("preserveAspectRatio", "none");
+  if (points.length < 2) {
+    return svg;
+  }
+
+  const times = points.map((p) => Date.parse(p.t));
+  const values = points.map((p) => p.v);
+  const minT = Math.min(...times);
+  const spanT = Math.max(...times) - minT || 1;
+  const maxV = Math.max(...values) || 1;
+
+  const line = document.createElementNS("http://www.w3.org/2000/svg", "polyline");
+  line.setAttribute("points", points.map((p, i) => {
+    const x = ((times[i] - minT) / spanT) * 160;
+    const y = 31 - (values[i] / maxV) * 30;
+    return x.toFixed(1) + "," + y.toFixed(1);
+  }).join(" "));
+  svg.appendChild(line);
+  return svg;
+}
+
+function cell(row, content, className) {
+  const td = document.createElement("td");
+  if (className) {
+    td.className = className;
+  }
+  if (content instanceof Node) {
+    td.appendChild(content);
+  } else {
+    td.textContent = content;
+  }
+  row.appendChild(td);
+  return td;
+}
+
+// toggle switches a single outlet of devices with several of them, the
+// whole device otherwise.
+async function toggle(device, button, single) {
+  button.disabled = true;
+  const state = device.switch === "ON" ? "off" : "on";
+  const path = single ? "/" : "/outlets/" + device.outlet + "/";
+  try {
+    const res = await fetch(api + "devices/" + device.id + path + state, { method: "POST" });
+    const result = await res.json();
+    if (result.result !== "acked") {
+      alert((device.alias || device.id) + ": " + (result.error || result.result));
+    }
+  } finally {
+    refresh();
+  }
+}
+
+function render(devices) {
+  const strips = new Set(devices.filter((device) => device.outlet > 1).map((device) => device.id));
+  tbody.replaceChildren(...devices.map((device) => {
+    const row = document.createElement("tr");
+
+    const name = document.createElement("div");
+    name.textContent = device.alias || device.id;
+    const id = document.createElement("div");
+    id.className = "id";
+    id.textContent = [device.alias ? device.id : "", strips.has(device.id) ? "outlet " + device.outlet : ""]
+      .filter((part) => part !== "")
+      .join(" · ");
+    const label = document.createElement("div");
+    label.append(name, id);
+    cell(row, label);
+
+    cell(row, device.online ? "online" : "offline", device.online ? "online" : "offline");
+    cell(row, device.firmware_version || "-");
+    cell(row, fmt(device.power, "W"), "num");
+    cell(row, fmt(device.voltage, "V"), "num");
+    cell(row, fmt(device.current, "A"), "num");
+    cell(row, sparkline(device.power_history));
+
+    const button = document.createElement("button");
+    button.textContent = device.switch || "?";
+    button.className = device.switch === "ON" ? "on" : "";
+    button.disabled = !device.online;
+    button.addEventListener("click", () => toggle(device, button, !strips.has(device.id)));
+    cell(row, button);
+
+    return row;
+  }));
+  document.getElementById("updated").textContent = "updated " + new Date().toLocaleTimeString();
+}
+
+let pending = null;
+
+async function refresh() {
+  pending = null;
+  const res = await fetch("devices.json");
+  render(await res.json());
+}
+
+function schedule() {
+  if (pending === null) {
+    pending = setTimeout(refresh, 250);
+  }
+}
+
+const events = new EventSource(api + "events?kind=connected,disconnected,status");
+events.onmessage = schedule;
+["connected", "disconnected", "message"].forEach((kind) => events.addEventListener(kind, schedule));
+
+refresh();
+setInterval(refresh, 30000);
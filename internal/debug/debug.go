@@ -0,0 +1,57 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+const (
+	DevicesPath = "/debug/devices"
+	PprofPrefix = "/debug/pprof/"
+	// LogLevelPath serves ghoma.LogLevels, to change log levels at runtime.
+	LogLevelPath      = "/debug/log-level"
+	UnknownFramesPath = "/debug/unknown-frames"
+)
+
+// Devices dumps the internal state of every connected device.
+func Devices(server *ghoma.Server, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		states := make([]ghoma.DeviceState, 0)
+		for _, dev := range server.Devices() {
+			states = append(states, dev.State())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(states); err != nil {
+			logger.Error("unable to write devices state", zap.Error(err))
+		}
+	}
+}
+
+// UnknownFrames dumps the payloads devices sent that could not be decoded.
+func UnknownFrames(server *ghoma.Server, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(server.UnknownFrames()); err != nil {
+			logger.Error("unable to write unknown frames", zap.Error(err))
+		}
+	}
+}
+
+// RegisterPprof serves the runtime profiles under PprofPrefix, the default
+// mux is not used so they are only exposed when asked for.
+func RegisterPprof(mux *http.ServeMux) {
+	mux.HandleFunc(PprofPrefix, pprof.Index)
+	mux.HandleFunc(PprofPrefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(PprofPrefix+"profile", pprof.Profile)
+	mux.HandleFunc(PprofPrefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(PprofPrefix+"trace", pprof.Trace)
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/provision"
+)
+
+type Options struct {
+	// Interval between two discovery broadcasts, plugs that did not answer
+	// the last three are forgotten.
+	Interval time.Duration
+	Probe    provision.Options
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// Plug is a plug that answered the discovery, Registered tells whether it
+// ever connected to the ghoma server.
+type Plug struct {
+	provision.Plug
+	ID         string    `json:"id"`
+	Registered bool      `json:"registered"`
+	Connected  bool      `json:"connected"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// Discoverer periodically broadcasts the discovery probe of the plugs
+// configuration port and keeps track of the plugs answering it.
+type Discoverer struct {
+	server  *ghoma.Server
+	options Options
+	logger  *zap.Logger
+	desc    *prometheus.Desc
+
+	mu    sync.RWMutex
+	plugs map[string]*Plug
+}
+
+func New(server *ghoma.Server, options Options) *Discoverer {
+	if options.Interval <= 0 {
+		options.Interval = 5 * time.Minute
+	}
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+	return &Discoverer{
+		server:  server,
+		options: options,
+		logger:  options.Logger.With(zap.String("component", "discovery")),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("ghoma", "", "discovered_devices"),
+			"plugs answering the LAN discovery, by whether they registered on the ghoma server",
+			[]string{"registered"}, nil,
+		),
+		plugs: make(map[string]*Plug),
+	}
+}
+
+// Start discovers plugs right away then on every interval until ctx is done.
+func (d *Discoverer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(d.options.Interval)
+		defer ticker.Stop()
+		for {
+			d.Scan(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Scan broadcasts a single discovery probe and records the answers.
+func (d *Discoverer) Scan(ctx context.Context) {
+	plugs, err := provision.Discover(ctx, d.options.Probe)
+	if err != nil {
+		d.logger.Error("discovery failed", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, p := range plugs {
+		known, exist := d.plugs[p.MAC]
+		if !exist {
+			known = &Plug{ID: p.ID(), FirstSeen: now}
+			d.plugs[p.MAC] = known
+			d.logger.Info("plug discovered", zap.String("ip", p.IP), zap.String("mac", p.MAC), zap.String("device_id", known.ID))
+		}
+		known.Plug = p
+		known.LastSeen = now
+	}
+	for mac, p := range d.plugs {
+		if now.Sub(p.LastSeen) > 3*d.options.Interval {
+			delete(d.plugs, mac)
+		}
+	}
+}
+
+// Plugs returns every plug discovered, sorted by ID.
+func (d *Discoverer) Plugs() []Plug {
+	registered := make(map[string]bool)
+	for _, info := range d.server.Known() {
+		registered[info.ID] = true
+	}
+	connected := make(map[string]bool)
+	for _, dev := range d.server.Devices() {
+		connected[dev.ID] = true
+	}
+
+	d.mu.RLock()
+	plugs := make([]Plug, 0, len(d.plugs))
+	for _, p := range d.plugs {
+		plug := *p
+		plug.Registered = registered[plug.ID]
+		plug.Connected = connected[plug.ID]
+		plugs = append(plugs, plug)
+	}
+	d.mu.RUnlock()
+
+	sort.Slice(plugs, func(i, j int) bool {
+		return plugs[i].ID < plugs[j].ID
+	})
+	return plugs
+}
+
+// Unregistered returns the plugs discovered that never registered, which
+// usually still point to the vendor cloud.
+func (d *Discoverer) Unregistered() []Plug {
+	plugs := make([]Plug, 0)
+	for _, p := range d.Plugs() {
+		if !p.Registered {
+			plugs = append(plugs, p)
+		}
+	}
+	return plugs
+}
+
+func (d *Discoverer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+func (d *Discoverer) Collect(ch chan<- prometheus.Metric) {
+	var registered, unregistered float64
+	for _, p := range d.Plugs() {
+		if p.Registered {
+			registered++
+		} else {
+			unregistered++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, registered, "true")
+	ch <- prometheus.MustNewConstMetric(d.desc, prometheus.GaugeValue, unregistered, "false")
+}
@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/internal/provision"
+	"github.com/eliecharra/ghoma/internal/provision/provisiontest"
+)
+
+func TestDiscoverer(t *testing.T) {
+	plug, err := provisiontest.NewPlug("ACCF23D78A1C")
+	require.NoError(t, err)
+	defer plug.Close()
+
+	server := ghoma.NewServer()
+	d := New(server, Options{
+		Interval: time.Minute,
+		Probe:    provision.Options{Broadcast: "127.0.0.1", Port: plug.Port(), Timeout: 200 * time.Millisecond},
+	})
+	d.Scan(context.Background())
+
+	plugs := d.Unregistered()
+	require.Len(t, plugs, 1)
+	require.Equal(t, "d78a1c", plugs[0].ID)
+	require.Equal(t, "127.0.0.1", plugs[0].IP)
+	require.Equal(t, "ACCF23D78A1C", plugs[0].MAC)
+	require.Equal(t, "HF-LPB100", plugs[0].Module)
+	require.NoError(t, testutil.CollectAndCompare(d, strings.NewReader(`
+# HELP ghoma_discovered_devices plugs answering the LAN discovery, by whether they registered on the ghoma server
+# TYPE ghoma_discovered_devices gauge
+ghoma_discovered_devices{registered="false"} 1
+ghoma_discovered_devices{registered="true"} 0
+`)))
+
+	server.Restore([]ghoma.DeviceInfo{{ID: "d78a1c"}})
+	require.Empty(t, d.Unregistered())
+	plugs = d.Plugs()
+	require.Len(t, plugs, 1)
+	require.True(t, plugs[0].Registered)
+	require.False(t, plugs[0].Connected)
+}
+
+func TestDiscoverer_Forget(t *testing.T) {
+	d := New(ghoma.NewServer(), Options{
+		Interval: time.Minute,
+		// Nothing answers on this port
+		Probe: provision.Options{Broadcast: "127.0.0.1", Port: 9, Timeout: 10 * time.Millisecond},
+	})
+	d.plugs["ACCF23D78A1C"] = &Plug{ID: "d78a1c", LastSeen: time.Now().Add(-4 * time.Minute)}
+	d.plugs["ACCF230A0B0C"] = &Plug{ID: "0a0b0c", LastSeen: time.Now().Add(-2 * time.Minute)}
+	d.Scan(context.Background())
+
+	plugs := d.Plugs()
+	require.Len(t, plugs, 1)
+	require.Equal(t, "0a0b0c", plugs[0].ID)
+}
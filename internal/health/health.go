@@ -0,0 +1,92 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+const (
+	LivePath  = "/healthz"
+	ReadyPath = "/readyz"
+)
+
+type Options struct {
+	// MinDevices is the number of connected devices required to be ready.
+	MinDevices int
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+// Health serves the liveness and readiness probes.
+type Health struct {
+	server   *ghoma.Server
+	gatherer prometheus.Gatherer
+	options  Options
+}
+
+func New(server *ghoma.Server, gatherer prometheus.Gatherer, options Options) *Health {
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+	return &Health{
+		server:   server,
+		gatherer: gatherer,
+		options:  options,
+	}
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Live answers as long as the process serves HTTP.
+func (h *Health) Live(w http.ResponseWriter, _ *http.Request) {
+	h.write(w, http.StatusOK, report{Status: "ok"})
+}
+
+// Ready checks the ghoma listener is bound, the metrics registry gathers
+// without error and enough devices are connected.
+func (h *Health) Ready(w http.ResponseWriter, _ *http.Request) {
+	checks := map[string]error{
+		"listener": nil,
+		"registry": nil,
+		"devices":  nil,
+	}
+	if !h.server.Listening() {
+		checks["listener"] = fmt.Errorf("ghoma server is not listening")
+	}
+	if _, err := h.gatherer.Gather(); err != nil {
+		checks["registry"] = err
+	}
+	if connected := len(h.server.Devices()); connected < h.options.MinDevices {
+		checks["devices"] = fmt.Errorf("%d devices connected, %d required", connected, h.options.MinDevices)
+	}
+
+	res := report{Status: "ok", Checks: make(map[string]string, len(checks))}
+	status := http.StatusOK
+	for name, err := range checks {
+		res.Checks[name] = "ok"
+		if err != nil {
+			res.Checks[name] = err.Error()
+			res.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	h.write(w, status, res)
+}
+
+func (h *Health) write(w http.ResponseWriter, status int, res report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.options.Logger.Error("unable to write health report", zap.Error(err))
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+func TestHealth_Ready(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listening := ghoma.NewServer(ghoma.WithListenAddr("127.0.0.1:0"))
+	require.NoError(t, listening.Start(ctx))
+
+	tests := []struct {
+		name           string
+		server         *ghoma.Server
+		options        Options
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "ready",
+			server:         listening,
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"listener": "ok", "registry": "ok", "devices": "ok"},
+		},
+		{
+			name:           "not listening",
+			server:         ghoma.NewServer(),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"listener": "ghoma server is not listening", "registry": "ok", "devices": "ok"},
+		},
+		{
+			name:           "not enough devices",
+			server:         listening,
+			options:        Options{MinDevices: 1},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"listener": "ok", "registry": "ok", "devices": "0 devices connected, 1 required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(tt.server, prometheus.NewRegistry(), tt.options)
+			rec := httptest.NewRecorder()
+			h.Ready(rec, httptest.NewRequest(http.MethodGet, ReadyPath, nil))
+
+			require.Equal(t, tt.expectedStatus, rec.Code)
+			var res report
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+			require.Equal(t, tt.expectedChecks, res.Checks)
+		})
+	}
+}
+
+func TestHealth_Live(t *testing.T) {
+	h := New(ghoma.NewServer(), prometheus.NewRegistry(), Options{})
+	rec := httptest.NewRecorder()
+	h.Live(rec, httptest.NewRequest(http.MethodGet, LivePath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+}
@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Segments are append-only files holding one day of downsampled points,
+// named after the UTC day they cover (e.g. 20231001.seg). Each record is:
+//
+//	uint8   series name length
+//	[]byte  series name, the device ID followed by /outlet past the first outlet
+//	uint8   kind length
+//	[]byte  kind
+//	int64   unix timestamp in milliseconds, big endian
+//	float64 value, big endian IEEE 754
+const (
+	segmentExt    = ".seg"
+	segmentLayout = "20060102"
+)
+
+type record struct {
+	series
+	point
+}
+
+func segmentName(t time.Time) string {
+	return t.UTC().Format(segmentLayout) + segmentExt
+}
+
+func appendSegment(dir string, day time.Time, records []record) error {
+	f, err := os.OpenFile(filepath.Join(dir, segmentName(day)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range records {
+		if err := writeRecord(w, r); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeRecord(w io.Writer, r record) error {
+	name := r.name()
+	if len(name) > math.MaxUint8 || len(r.Kind) > math.MaxUint8 {
+		return fmt.Errorf("series %s/%s is too long", name, r.Kind)
+	}
+	buf := make([]byte, 0, 2+len(name)+len(r.Kind)+16)
+	buf = append(buf, byte(len(name)))
+	buf = append(buf, name...)
+	buf = append(buf, byte(len(r.Kind)))
+	buf = append(buf, r.Kind...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Time.UnixMilli()))
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(r.Value))
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRecord returns the next record and its size in bytes.
+func readRecord(r *bufio.Reader) (record, int64, error) {
+	name, err := readString(r)
+	if err != nil {
+		return record{}, 0, err
+	}
+	kind, err := readString(r)
+	if err != nil {
+		return record{}, 0, errors.Join(io.ErrUnexpectedEOF, err)
+	}
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return record{}, 0, errors.Join(io.ErrUnexpectedEOF, err)
+	}
+	size := int64(2 + len(name) + len(kind) + len(buf))
+	return record{
+		series: parseSeries(name, kind),
+		point: point{
+			Time:  time.UnixMilli(int64(binary.BigEndian.Uint64(buf[:8]))),
+			Value: math.Float64frombits(binary.BigEndian.Uint64(buf[8:])),
+		},
+	}, size, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	length, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readSegments returns the points of s found in segments between from and to.
+func readSegments(dir string, s series, from, to time.Time) ([]point, error) {
+	days, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var points []point
+	for _, day := range days {
+		if day.Before(from.UTC().Truncate(24*time.Hour)) || day.After(to) {
+			continue
+		}
+		p, err := readSegment(filepath.Join(dir, segmentName(day)), s, from, to)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p...)
+	}
+	return points, nil
+}
+
+func readSegment(path string, s series, from, to time.Time) ([]point, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []point
+	r := bufio.NewReader(f)
+	for {
+		rec, _, err := readRecord(r)
+		// A truncated trailing record is left by a crash during a flush,
+		// see repairSegment
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.series != s || rec.Time.Before(from) || rec.Time.After(to) {
+			continue
+		}
+		points = append(points, rec.point)
+	}
+}
+
+// repairSegment cuts the truncated trailing record a crash during a flush
+// may leave, so that records appended afterwards stay aligned. It returns
+// the number of bytes removed and the time of the latest record.
+func repairSegment(path string) (int64, time.Time, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var good int64
+	var latest time.Time
+	r := bufio.NewReader(f)
+	for {
+		rec, size, err := readRecord(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		good += size
+		if rec.Time.After(latest) {
+			latest = rec.Time
+		}
+	}
+	if good == info.Size() {
+		return 0, latest, nil
+	}
+	return info.Size() - good, latest, f.Truncate(good)
+}
+
+// listSegments returns the days covered by segment files, oldest first.
+func listSegments(dir string) ([]time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		day, err := time.Parse(segmentLayout, strings.TrimSuffix(e.Name(), segmentExt))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
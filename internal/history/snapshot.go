@@ -0,0 +1,63 @@
+package history
+
+import (
+	"encoding/json"
+)
+
+// pending are the readings of a series not written to disk yet.
+type pending struct {
+	Device string  `json:"device"`
+	Outlet int     `json:"outlet"`
+	Kind   string  `json:"kind"`
+	Points []point `json:"points"`
+}
+
+// Snapshot returns the readings not written to disk yet, such as the bucket
+// in progress on shutdown, to be given to Restore after a restart.
+func (s *Store) Snapshot() (json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := make([]pending, 0, len(s.series))
+	for key, b := range s.series {
+		var points []point
+		for _, p := range b.all() {
+			if p.Time.After(b.flushed) {
+				points = append(points, p)
+			}
+		}
+		if len(points) > 0 {
+			state = append(state, pending{Device: key.Device, Outlet: key.Outlet, Kind: key.Kind, Points: points})
+		}
+	}
+	return json.Marshal(state)
+}
+
+// Restore loads a snapshot, series reported since the store was created are
+// kept over the restored ones. Readings of buckets already on disk are
+// skipped, a snapshot taken before the last flush would duplicate them.
+func (s *Store) Restore(data json.RawMessage) error {
+	var state []pending
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range state {
+		key := series{Device: p.Device, Outlet: p.Outlet, Kind: p.Kind}
+		if _, exist := s.series[key]; exist {
+			continue
+		}
+		b := &buffer{points: make([]point, s.options.MemoryPoints)}
+		for _, point := range p.Points {
+			if !point.Time.Before(s.flushed.Add(s.options.Resolution)) {
+				b.add(point)
+			}
+		}
+		if b.next > 0 || b.full {
+			s.series[key] = b
+		}
+	}
+	return nil
+}
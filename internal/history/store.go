@@ -0,0 +1,318 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+var ErrSeriesNotFound = errors.New("no history for this device and kind")
+
+type Options struct {
+	// Dir holds segment files, history is kept in memory only when empty.
+	Dir string
+	// Retention is how long segment files are kept on disk.
+	Retention time.Duration
+	// Resolution is the step raw readings are averaged to before being
+	// written to disk.
+	Resolution time.Duration
+	// FlushInterval is how often readings are written to disk.
+	FlushInterval time.Duration
+	// MemoryPoints is the number of raw readings kept in memory per series.
+	MemoryPoints int
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+type series struct {
+	Device string
+	Outlet int
+	Kind   string
+}
+
+// name identifies the series in segment files: the device ID, followed by
+// the outlet for outlets other than the first.
+func (s series) name() string {
+	if s.Outlet <= 1 {
+		return s.Device
+	}
+	return s.Device + "/" + strconv.Itoa(s.Outlet)
+}
+
+func parseSeries(name, kind string) series {
+	device, outlet, found := strings.Cut(name, "/")
+	s := series{Device: device, Outlet: 1, Kind: kind}
+	if n, err := strconv.Atoi(outlet); found && err == nil {
+		s.Outlet = n
+	}
+	return s
+}
+
+type point struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// Store keeps the readings of every device: raw readings in memory ring
+// buffers and downsampled readings in segment files on disk.
+type Store struct {
+	options Options
+
+	// flushed is the start of the latest bucket found on disk on open.
+	flushed time.Time
+
+	mu     sync.RWMutex
+	series map[series]*buffer
+}
+
+// buffer is a ring of raw readings, flushed marks the readings already
+// written to disk.
+type buffer struct {
+	points  []point
+	next    int
+	full    bool
+	flushed time.Time
+}
+
+func NewStore(options Options) (*Store, error) {
+	if options.Resolution <= 0 {
+		options.Resolution = time.Minute
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Minute
+	}
+	if options.MemoryPoints <= 0 {
+		options.MemoryPoints = 4096
+	}
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+	s := &Store{
+		options: options,
+		series:  make(map[series]*buffer),
+	}
+	if options.Dir != "" {
+		if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+			return nil, err
+		}
+		// Only the latest segment is appended to, older ones are left as
+		// they are, their truncated records are skipped when read.
+		days, err := listSegments(options.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(days) > 0 {
+			path := filepath.Join(options.Dir, segmentName(days[len(days)-1]))
+			cut, latest, err := repairSegment(path)
+			if err != nil {
+				return nil, err
+			}
+			if cut > 0 {
+				options.Logger.Warn("truncated history segment repaired", zap.String("path", path), zap.Int64("bytes", cut))
+			}
+			s.flushed = latest
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) HandleStatus(dev *ghoma.Device, msg protocol.Message) {
+	if msg.Status.Energy == nil {
+		return
+	}
+	s.add(series{Device: dev.ID, Outlet: msg.Status.Outlet, Kind: msg.Status.Energy.Kind()}, point{
+		Time:  time.Now(),
+		Value: msg.Status.Energy.Measurement().Value,
+	})
+}
+
+func (s *Store) add(key series, p point) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, exist := s.series[key]
+	if !exist {
+		b = &buffer{points: make([]point, s.options.MemoryPoints)}
+		s.series[key] = b
+	}
+	b.add(p)
+}
+
+func (b *buffer) add(p point) {
+	b.points[b.next] = p
+	b.next = (b.next + 1) % len(b.points)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *buffer) all() []point {
+	var points []point
+	if b.full {
+		points = append(points, b.points[b.next:]...)
+	}
+	return append(points, b.points[:b.next]...)
+}
+
+// Run flushes readings to disk periodically until ctx is done. Only whole
+// buckets are flushed, the bucket in progress on shutdown is left to
+// Snapshot so it is not written twice.
+func (s *Store) Run(ctx context.Context) {
+	if s.options.Dir == "" {
+		return
+	}
+	ticker := time.NewTicker(s.options.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Flush(time.Now().Truncate(s.options.Resolution)); err != nil {
+				s.options.Logger.Error("unable to flush history", zap.Error(err))
+			}
+			return
+		case <-ticker.C:
+			if err := s.Flush(time.Now().Truncate(s.options.Resolution)); err != nil {
+				s.options.Logger.Error("unable to flush history", zap.Error(err))
+			}
+			if err := s.cleanup(time.Now()); err != nil {
+				s.options.Logger.Error("unable to apply history retention", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush downsamples readings received before until and appends them to the
+// segment files.
+func (s *Store) Flush(until time.Time) error {
+	if s.options.Dir == "" {
+		return nil
+	}
+
+	records := make(map[time.Time][]record)
+	s.mu.Lock()
+	for key, b := range s.series {
+		var pending []point
+		for _, p := range b.all() {
+			if p.Time.After(b.flushed) && p.Time.Before(until) {
+				pending = append(pending, p)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		for _, a := range aggregate(pending, time.Unix(0, 0), s.options.Resolution) {
+			day := a.Time.UTC().Truncate(24 * time.Hour)
+			records[day] = append(records[day], record{series: key, point: point{Time: a.Time, Value: a.Avg}})
+		}
+		b.flushed = pending[len(pending)-1].Time
+	}
+	s.mu.Unlock()
+
+	for day, r := range records {
+		if err := appendSegment(s.options.Dir, day, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) cleanup(now time.Time) error {
+	if s.options.Retention <= 0 {
+		return nil
+	}
+	days, err := listSegments(s.options.Dir)
+	if err != nil {
+		return err
+	}
+	limit := now.Add(-s.options.Retention).UTC().Truncate(24 * time.Hour)
+	for _, day := range days {
+		if day.Before(limit) {
+			if err := os.Remove(filepath.Join(s.options.Dir, segmentName(day))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query returns readings of the given device outlet and kind between from
+// and to, aggregated per step. Readings still in memory are preferred over
+// the downsampled ones on disk.
+func (s *Store) Query(device string, outlet int, kind string, from, to time.Time, step time.Duration) ([]Aggregate, error) {
+	key := series{Device: device, Outlet: outlet, Kind: kind}
+
+	s.mu.RLock()
+	var memory []point
+	b, exist := s.series[key]
+	if exist {
+		for _, p := range b.all() {
+			if !p.Time.Before(from) && !p.Time.After(to) {
+				memory = append(memory, p)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	var points []point
+	if s.options.Dir != "" {
+		diskTo := to
+		if len(memory) > 0 {
+			diskTo = memory[0].Time.Add(-s.options.Resolution)
+		}
+		disk, err := readSegments(s.options.Dir, key, from, diskTo)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, disk...)
+	}
+	points = append(points, memory...)
+
+	if !exist && len(points) == 0 {
+		return nil, ErrSeriesNotFound
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return aggregate(points, from, step), nil
+}
+
+type Aggregate struct {
+	Time  time.Time `json:"time"`
+	Avg   float64   `json:"avg"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Count int       `json:"count"`
+}
+
+// aggregate groups sorted points in buckets of step aligned on origin.
+func aggregate(points []point, origin time.Time, step time.Duration) []Aggregate {
+	res := make([]Aggregate, 0)
+	for _, p := range points {
+		bucket := origin.Add(p.Time.Sub(origin).Truncate(step))
+		if p.Time.Before(origin) {
+			bucket = bucket.Add(-step)
+		}
+		if n := len(res); n > 0 && res[n-1].Time.Equal(bucket) {
+			a := &res[n-1]
+			a.Count++
+			a.Avg += (p.Value - a.Avg) / float64(a.Count)
+			a.Min = min(a.Min, p.Value)
+			a.Max = max(a.Max, p.Value)
+			continue
+		}
+		res = append(res, Aggregate{Time: bucket, Avg: p.Value, Min: p.Value, Max: p.Value, Count: 1})
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_Query(t *testing.T) {
+	start := time.Date(2023, 10, 1, 23, 58, 0, 0, time.UTC)
+	power := series{Device: "d78a1c", Outlet: 1, Kind: "POWER"}
+	dir := t.TempDir()
+
+	store, err := NewStore(Options{Dir: dir, Resolution: time.Minute, MemoryPoints: 4})
+	require.NoError(t, err)
+	for i, v := range []float64{10, 20, 30, 40, 50, 60} {
+		store.add(power, point{Time: start.Add(time.Duration(i) * 30 * time.Second), Value: v})
+		if i == 1 {
+			require.NoError(t, store.Flush(start.Add(time.Minute)))
+		}
+	}
+	require.NoError(t, store.Flush(start.Add(3*time.Minute)))
+
+	days, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{
+		time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC),
+	}, days)
+
+	// The two oldest readings were overwritten in memory and only their
+	// average is still available on disk
+	got, err := store.Query("d78a1c", 1, "POWER", start, start.Add(time.Hour), time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []Aggregate{
+		{Time: start, Avg: 15, Min: 15, Max: 15, Count: 1},
+		{Time: start.Add(time.Minute), Avg: 35, Min: 30, Max: 40, Count: 2},
+		{Time: start.Add(2 * time.Minute), Avg: 55, Min: 50, Max: 60, Count: 2},
+	}, got)
+
+	restarted, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	got, err = restarted.Query("d78a1c", 1, "POWER", start, start.Add(time.Hour), 2*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []Aggregate{
+		{Time: start, Avg: 25, Min: 15, Max: 35, Count: 2},
+		{Time: start.Add(2 * time.Minute), Avg: 55, Min: 55, Max: 55, Count: 1},
+	}, got)
+
+	_, err = restarted.Query("d78a1c", 1, "VOLTAGE", start, start.Add(time.Hour), time.Minute)
+	require.ErrorIs(t, err, ErrSeriesNotFound)
+}
+
+func TestStore_Outlets(t *testing.T) {
+	at := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	store, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	store.add(series{Device: "d78a1c", Outlet: 1, Kind: "POWER"}, point{Time: at, Value: 10})
+	store.add(series{Device: "d78a1c", Outlet: 2, Kind: "POWER"}, point{Time: at, Value: 20})
+	require.NoError(t, store.Flush(at.Add(time.Minute)))
+
+	restarted, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	for outlet, want := range map[int]float64{1: 10, 2: 20} {
+		got, err := restarted.Query("d78a1c", outlet, "POWER", at, at.Add(time.Hour), time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, []Aggregate{{Time: at, Avg: want, Min: want, Max: want, Count: 1}}, got)
+	}
+}
+
+func TestStore_Retention(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"20231001.seg", "20231005.seg", "20231006.seg", "notes.txt"} {
+		require.NoError(t, os.WriteFile(dir+"/"+name, nil, 0o644))
+	}
+
+	store, err := NewStore(Options{Dir: dir, Retention: 48 * time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, store.cleanup(time.Date(2023, 10, 6, 12, 0, 0, 0, time.UTC)))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"20231005.seg", "20231006.seg", "notes.txt"}, names)
+}
+
+func TestStore_Repair(t *testing.T) {
+	at := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	power := series{Device: "d78a1c", Outlet: 1, Kind: "POWER"}
+	dir := t.TempDir()
+
+	store, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	store.add(power, point{Time: at, Value: 10})
+	require.NoError(t, store.Flush(at.Add(time.Minute)))
+
+	// A crash during a flush leaves part of a record behind
+	f, err := os.OpenFile(dir+"/20231001.seg", os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{6, 'd', '7', '8'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restarted, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	restarted.add(power, point{Time: at.Add(time.Minute), Value: 20})
+	require.NoError(t, restarted.Flush(at.Add(2*time.Minute)))
+
+	got, err := readSegments(dir, power, at, at.Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, []float64{10, 20}, values(got))
+}
+
+func TestStore_Snapshot(t *testing.T) {
+	at := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	power := series{Device: "d78a1c", Outlet: 1, Kind: "POWER"}
+	dir := t.TempDir()
+
+	store, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+	require.NoError(t, err)
+	store.add(power, point{Time: at, Value: 10})
+	store.add(power, point{Time: at.Add(time.Minute), Value: 20})
+	stale, err := store.Snapshot()
+	require.NoError(t, err)
+	// On shutdown the bucket in progress is kept for the snapshot
+	require.NoError(t, store.Flush(at.Add(90*time.Second).Truncate(time.Minute)))
+	state, err := store.Snapshot()
+	require.NoError(t, err)
+
+	for name, snapshot := range map[string][]byte{"on shutdown": state, "before the last flush": stale} {
+		t.Run(name, func(t *testing.T) {
+			// Only the first bucket was on disk before the restart
+			require.NoError(t, os.Truncate(dir+"/20231001.seg", int64(2+len("d78a1c")+len("POWER")+16)))
+			restarted, err := NewStore(Options{Dir: dir, Resolution: time.Minute})
+			require.NoError(t, err)
+			require.NoError(t, restarted.Restore(snapshot))
+			restarted.add(power, point{Time: at.Add(90 * time.Second), Value: 40})
+			require.NoError(t, restarted.Flush(at.Add(2*time.Minute)))
+
+			got, err := readSegments(dir, power, at, at.Add(time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, []float64{10, 30}, values(got), "the bucket in progress is written once")
+		})
+	}
+}
+
+func values(points []point) []float64 {
+	var res []float64
+	for _, p := range points {
+		res = append(res, p.Value)
+	}
+	return res
+}
@@ -0,0 +1,44 @@
+package influx
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const measurement = "ghoma"
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// point is a single line of InfluxDB line protocol, e.g.
+// ghoma,alias=desk,device=d78a1c,kind=POWER,outlet=1,unit=W value=44.04 1696111200000000000
+type point struct {
+	device, alias, kind, unit string
+	outlet                    int
+	value                     float64
+	time                      time.Time
+}
+
+func (p point) line() string {
+	b := strings.Builder{}
+	b.WriteString(measurement)
+	if p.alias != "" {
+		b.WriteString(",alias=")
+		b.WriteString(tagEscaper.Replace(p.alias))
+	}
+	b.WriteString(",device=")
+	b.WriteString(tagEscaper.Replace(p.device))
+	b.WriteString(",kind=")
+	b.WriteString(tagEscaper.Replace(p.kind))
+	b.WriteString(",outlet=")
+	b.WriteString(strconv.Itoa(p.outlet))
+	if p.unit != "" {
+		b.WriteString(",unit=")
+		b.WriteString(tagEscaper.Replace(p.unit))
+	}
+	b.WriteString(" value=")
+	b.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(p.time.UnixNano(), 10))
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package influx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolExt = ".lp"
+
+// spool keeps batches that could not be written in files, one batch per
+// file, deleting the oldest ones when the directory grows over maxBytes.
+type spool struct {
+	dir      string
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+func newSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &spool{dir: dir, maxBytes: maxBytes}, nil
+}
+
+func (s *spool) push(batch []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolExt))
+	if err := os.WriteFile(name, batch, 0o644); err != nil {
+		return err
+	}
+	return s.trim()
+}
+
+// trim removes the oldest batches until the spool fits in maxBytes.
+func (s *spool) trim() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var size int64
+	sizes := make([]int64, len(files))
+	for i, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		size += info.Size()
+	}
+	for i := 0; size > s.maxBytes && i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+		size -= sizes[i]
+	}
+	return nil
+}
+
+// drain sends spooled batches oldest first, stopping at the first failure.
+func (s *spool) drain(send func([]byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		batch, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), spoolExt) {
+			files = append(files, filepath.Join(s.dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
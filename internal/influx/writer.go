@@ -0,0 +1,259 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+const queueSize = 1024
+
+type Options struct {
+	// URL is the base address of the InfluxDB server, e.g. http://influx:8086
+	URL    string
+	Org    string
+	Bucket string
+	Token  string
+
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+	// MaxRetries is the number of retries of a batch before it is spooled.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled every retry.
+	RetryBackoff time.Duration
+
+	// BufferDir holds batches that could not be written while the endpoint
+	// is down, up to BufferMaxBytes (64 MiB when zero), oldest batches are
+	// deleted past it. Batches are dropped when BufferDir is empty.
+	BufferDir      string
+	BufferMaxBytes int64
+
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+
+	Aliases map[string]string
+}
+
+// permanentError is returned for rejected batches that must not be retried.
+type permanentError struct {
+	status int
+	body   string
+}
+
+func (e permanentError) Error() string {
+	return fmt.Sprintf("write rejected with status %d: %s", e.status, e.body)
+}
+
+// Writer pushes every status event to the InfluxDB v2 write API.
+type Writer struct {
+	options Options
+	client  *http.Client
+	points  chan point
+	spool   *spool
+	logger  *zap.Logger
+}
+
+func NewWriter(options Options) (*Writer, error) {
+	if _, err := url.Parse(options.URL); err != nil {
+		return nil, err
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 10 * time.Second
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = time.Second
+	}
+	if options.BufferMaxBytes <= 0 {
+		options.BufferMaxBytes = 64 << 20
+	}
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+
+	w := &Writer{
+		options: options,
+		client:  &http.Client{Timeout: options.Timeout},
+		points:  make(chan point, queueSize),
+		logger:  options.Logger.With(zap.String("output", "influx")),
+	}
+	if options.BufferDir != "" {
+		s, err := newSpool(options.BufferDir, options.BufferMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		w.spool = s
+	}
+	return w, nil
+}
+
+func (w *Writer) HandleEvent(e ghoma.Event) {
+	if e.Message == nil || e.Message.Status == nil {
+		return
+	}
+
+	status := e.Message.Status
+	p := point{
+		device: e.Device,
+		alias:  w.options.Aliases[e.Device],
+		outlet: status.Outlet,
+		time:   e.Time,
+	}
+	switch {
+	case status.Energy != nil:
+		m := status.Energy.Measurement()
+		p.kind = m.Kind
+		p.unit = m.Unit
+		p.value = m.Value
+	case status.Switch != nil:
+		p.kind = "SWITCH"
+		if *status.Switch {
+			p.value = 1
+		}
+	default:
+		return
+	}
+
+	select {
+	case w.points <- p:
+	default:
+		w.logger.Warn("queue full, dropping point", zap.String("device", p.device), zap.String("kind", p.kind))
+	}
+}
+
+// Run sends batches until ctx is done, then flushes what is left within
+// Timeout.
+func (w *Writer) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.options.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []string
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			w.flush(ctx, batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for len(w.points) > 0 {
+				batch = append(batch, (<-w.points).line())
+			}
+			// The last batch is spooled when it cannot be written
+			// within Timeout, shutdown must not wait for its retries.
+			final, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.options.Timeout)
+			flush(final)
+			cancel()
+			return
+		case p := <-w.points:
+			batch = append(batch, p.line())
+			if len(batch) >= w.options.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+func (w *Writer) flush(ctx context.Context, lines []string) {
+	batch := []byte(strings.Join(lines, "\n") + "\n")
+
+	err := w.sendWithRetry(ctx, batch)
+	if err == nil {
+		if w.spool != nil {
+			if err := w.spool.drain(func(b []byte) error { return w.sendWithRetry(ctx, b) }); err != nil {
+				w.logger.Warn("unable to write buffered batches", zap.Error(err))
+			}
+		}
+		return
+	}
+
+	var rejected permanentError
+	if errors.As(err, &rejected) || w.spool == nil {
+		w.logger.Error("dropping batch", zap.Int("points", len(lines)), zap.Error(err))
+		return
+	}
+	w.logger.Warn("unable to write batch, buffering it on disk", zap.Int("points", len(lines)), zap.Error(err))
+	if err := w.spool.push(batch); err != nil {
+		w.logger.Error("unable to buffer batch", zap.Error(err))
+	}
+}
+
+func (w *Writer) sendWithRetry(ctx context.Context, batch []byte) error {
+	backoff := w.options.RetryBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = w.send(ctx, batch)
+		var rejected permanentError
+		if err == nil || errors.As(err, &rejected) || attempt >= w.options.MaxRetries {
+			return err
+		}
+		w.logger.Debug("retrying batch", zap.Int("attempt", attempt+1), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Writer) send(ctx context.Context, batch []byte) error {
+	body := &bytes.Buffer{}
+	gz := gzip.NewWriter(body)
+	if _, err := gz.Write(batch); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	query.Set("org", w.options.Org)
+	query.Set("bucket", w.options.Bucket)
+	query.Set("precision", "ns")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(w.options.URL, "/")+"/api/v2/write?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	if w.options.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.options.Token)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	switch {
+	case res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("write failed with status %d: %s", res.StatusCode, msg)
+	default:
+		return permanentError{status: res.StatusCode, body: string(msg)}
+	}
+}
@@ -0,0 +1,206 @@
+package influx
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+// influxStub stands in for the InfluxDB write API, answering with status
+// and recording every accepted body.
+type influxStub struct {
+	status   atomic.Int32
+	requests atomic.Int32
+	mu       sync.Mutex
+	bodies   []string
+}
+
+func (s *influxStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "plugs" || r.Header.Get("Authorization") != "Token secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	status := int(s.status.Load())
+	if status >= 300 {
+		w.WriteHeader(status)
+		return
+	}
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	body, _ := io.ReadAll(gz)
+	s.mu.Lock()
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newTestWriter(t *testing.T, stub *influxStub, dir string) *Writer {
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	w, err := NewWriter(Options{
+		URL:            srv.URL,
+		Org:            "home",
+		Bucket:         "plugs",
+		Token:          "secret",
+		MaxRetries:     1,
+		RetryBackoff:   time.Millisecond,
+		BufferDir:      dir,
+		BufferMaxBytes: 1024,
+		Aliases:        map[string]string{"d78a1c": "desk lamp"},
+	})
+	require.NoError(t, err)
+	return w
+}
+
+func powerEvent(value byte, at time.Time) ghoma.Event {
+	return outletPowerEvent(1, value, at)
+}
+
+func outletPowerEvent(outlet, value byte, at time.Time) ghoma.Event {
+	return ghoma.Event{
+		Kind:   ghoma.EventMessage,
+		Device: "d78a1c",
+		Time:   at,
+		Message: protocol.MustParse([]byte{
+			0x90, 0x01, 0x0a, 0xe0, 0x32, 0x23, 0xd7, 0x8a, 0x1c,
+			0xff, 0xfe, 0x01, 0x81, 0x39, 0x00, 0x00, outlet,
+			0x01, 0x00, 0x00, 0x11, value,
+		}),
+	}
+}
+
+func (w *Writer) pending() []string {
+	var lines []string
+	for len(w.points) > 0 {
+		lines = append(lines, (<-w.points).line())
+	}
+	return lines
+}
+
+func TestWriter_Flush(t *testing.T) {
+	stub := &influxStub{}
+	w := newTestWriter(t, stub, "")
+	at := time.Unix(1696111200, 0)
+
+	w.HandleEvent(powerEvent(0x34, at))
+	w.HandleEvent(outletPowerEvent(2, 0x34, at))
+	w.HandleEvent(ghoma.Event{Kind: ghoma.EventConnected, Device: "d78a1c", Time: at})
+	w.flush(context.Background(), w.pending())
+
+	require.Equal(t, []string{
+		"ghoma,alias=desk\\ lamp,device=d78a1c,kind=POWER,outlet=1,unit=W value=44.04 1696111200000000000\n" +
+			"ghoma,alias=desk\\ lamp,device=d78a1c,kind=POWER,outlet=2,unit=W value=44.04 1696111200000000000\n",
+	}, stub.bodies)
+}
+
+func TestWriter_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		bodies  int
+		spooled int
+	}{
+		{name: "flushed", status: http.StatusNoContent, bodies: 1},
+		{name: "spooled past the timeout", status: http.StatusServiceUnavailable, spooled: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &influxStub{}
+			stub.status.Store(int32(tt.status))
+			dir := t.TempDir()
+			w := newTestWriter(t, stub, dir)
+			w.options.FlushInterval = time.Hour
+			w.options.Timeout = 50 * time.Millisecond
+			w.options.MaxRetries = 10
+			w.options.RetryBackoff = time.Hour
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				w.Run(ctx)
+			}()
+
+			w.HandleEvent(powerEvent(0x34, time.Unix(1696111200, 0)))
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Run did not return after its last flush")
+			}
+			require.Len(t, stub.bodies, tt.bodies, "points left are flushed on shutdown")
+			files, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, files, tt.spooled)
+		})
+	}
+}
+
+func TestWriter_Buffering(t *testing.T) {
+	stub := &influxStub{}
+	stub.status.Store(http.StatusServiceUnavailable)
+	dir := t.TempDir()
+	w := newTestWriter(t, stub, dir)
+
+	w.HandleEvent(powerEvent(0x34, time.Unix(1696111200, 0)))
+	w.flush(context.Background(), w.pending())
+	require.Equal(t, int32(2), stub.requests.Load(), "batch should be retried once")
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	stub.status.Store(http.StatusNoContent)
+	w.HandleEvent(powerEvent(0x35, time.Unix(1696111210, 0)))
+	w.flush(context.Background(), w.pending())
+	assert.Equal(t, []string{
+		"ghoma,alias=desk\\ lamp,device=d78a1c,kind=POWER,outlet=1,unit=W value=44.05 1696111210000000000\n",
+		"ghoma,alias=desk\\ lamp,device=d78a1c,kind=POWER,outlet=1,unit=W value=44.04 1696111200000000000\n",
+	}, stub.bodies)
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestWriter_Rejected(t *testing.T) {
+	stub := &influxStub{}
+	stub.status.Store(http.StatusBadRequest)
+	dir := t.TempDir()
+	w := newTestWriter(t, stub, dir)
+
+	w.HandleEvent(powerEvent(0x34, time.Unix(1696111200, 0)))
+	w.flush(context.Background(), w.pending())
+	require.Equal(t, int32(1), stub.requests.Load(), "rejected batch should not be retried")
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestSpool_Trim(t *testing.T) {
+	s, err := newSpool(t.TempDir(), 10)
+	require.NoError(t, err)
+	require.NoError(t, s.push([]byte("first\n")))
+	require.NoError(t, s.push([]byte("second\n")))
+
+	var batches []string
+	require.NoError(t, s.drain(func(b []byte) error {
+		batches = append(batches, string(b))
+		return nil
+	}))
+	require.Equal(t, []string{"second\n"}, batches)
+}
@@ -1,20 +1,130 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Env                string `mapstructure:"env"`
+	ConfigFile         string `mapstructure:"config_file"`
 	ListenAddress      string `mapstructure:"listen_address"`
 	GhomaListenAddress string `mapstructure:"ghoma_listen_address"`
 	LogLevel           string `mapstructure:"log_level"`
+	// LogDeviceLevels override the log level of single devices, as id=level.
+	LogDeviceLevels []string `mapstructure:"log_device_levels"`
+	// LogSamplingFirst entries with the same message are logged per device
+	// every second, then one in LogSamplingThereafter. Zero disables it.
+	LogSamplingFirst      int `mapstructure:"log_sampling_first"`
+	LogSamplingThereafter int `mapstructure:"log_sampling_thereafter"`
+
+	// SnapshotFile keeps the device inventory and the latest readings across
+	// restarts, under the data directory of the working directory by
+	// default. Empty disables it.
+	SnapshotFile    string        `mapstructure:"snapshot_file"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	ReadyMinDevices int           `mapstructure:"ready_min_devices"`
+	DebugPprof      bool          `mapstructure:"debug_pprof"`
+	// DebugEndpoints serves the /debug/ endpoints other than pprof: device
+	// state, log levels and unknown frames. They are not authenticated.
+	DebugEndpoints bool `mapstructure:"debug_endpoints"`
+	// SnapshotInterval also writes the snapshot periodically, so the device
+	// inventory survives crashes.
+	SnapshotInterval time.Duration `mapstructure:"snapshot_interval"`
+
+	TraceDir      string   `mapstructure:"trace_dir"`
+	TraceDevices  []string `mapstructure:"trace_devices"`
+	TraceMaxBytes int64    `mapstructure:"trace_max_bytes"`
+	TraceMaxFiles int      `mapstructure:"trace_max_files"`
+
+	UnknownFramesMax int `mapstructure:"unknown_frames_max"`
+	// FirmwareExpected flags the devices running another firmware version.
+	FirmwareExpected string `mapstructure:"firmware_expected"`
+
+	MetricsTimestamps  bool `mapstructure:"metrics_timestamps"`
+	MetricsLegacyNames bool `mapstructure:"metrics_legacy_names"`
+
+	MetricsWindows        []time.Duration `mapstructure:"metrics_windows"`
+	MetricsPowerBuckets   []float64       `mapstructure:"metrics_power_buckets"`
+	MetricsCurrentBuckets []float64       `mapstructure:"metrics_current_buckets"`
+	// MetricsUnverifiedReadings exports the energy kinds whose scale is a
+	// guess, only power readings are exported otherwise.
+	MetricsUnverifiedReadings bool `mapstructure:"metrics_unverified_readings"`
+
+	CommandTimeout time.Duration `mapstructure:"command_timeout"`
+	CommandRetries int           `mapstructure:"command_retries"`
+
+	GhomaAllowedNetworks     []string      `mapstructure:"ghoma_allowed_networks"`
+	GhomaDeniedNetworks      []string      `mapstructure:"ghoma_denied_networks"`
+	GhomaAllowedDevices      []string      `mapstructure:"ghoma_allowed_devices"`
+	GhomaMaxConnections      int           `mapstructure:"ghoma_max_connections"`
+	GhomaMaxConnectionsPerIP int           `mapstructure:"ghoma_max_connections_per_ip"`
+	GhomaHandshakeTimeout    time.Duration `mapstructure:"ghoma_handshake_timeout"`
+	GhomaAcceptRate          float64       `mapstructure:"ghoma_accept_rate"`
+	GhomaAcceptBurst         int           `mapstructure:"ghoma_accept_burst"`
+
+	HistoryDir           string        `mapstructure:"history_dir"`
+	HistoryRetention     time.Duration `mapstructure:"history_retention"`
+	HistoryResolution    time.Duration `mapstructure:"history_resolution"`
+	HistoryFlushInterval time.Duration `mapstructure:"history_flush_interval"`
+
+	InfluxURL            string        `mapstructure:"influx_url"`
+	InfluxOrg            string        `mapstructure:"influx_org"`
+	InfluxBucket         string        `mapstructure:"influx_bucket"`
+	InfluxToken          string        `mapstructure:"influx_token"`
+	InfluxBatchSize      int           `mapstructure:"influx_batch_size"`
+	InfluxFlushInterval  time.Duration `mapstructure:"influx_flush_interval"`
+	InfluxMaxRetries     int           `mapstructure:"influx_max_retries"`
+	InfluxBufferDir      string        `mapstructure:"influx_buffer_dir"`
+	InfluxBufferMaxBytes int64         `mapstructure:"influx_buffer_max_bytes"`
+
+	RemoteWriteURL         string        `mapstructure:"remote_write_url"`
+	RemoteWriteInterval    time.Duration `mapstructure:"remote_write_interval"`
+	RemoteWriteUsername    string        `mapstructure:"remote_write_username"`
+	RemoteWritePassword    string        `mapstructure:"remote_write_password"`
+	RemoteWriteBearerToken string        `mapstructure:"remote_write_bearer_token"`
+	RemoteWriteMaxRetries  int           `mapstructure:"remote_write_max_retries"`
+	RemoteWriteWALDir      string        `mapstructure:"remote_write_wal_dir"`
+	RemoteWriteWALMaxBytes int64         `mapstructure:"remote_write_wal_max_bytes"`
+
+	DiscoveryEnabled   bool          `mapstructure:"discovery_enabled"`
+	DiscoveryInterval  time.Duration `mapstructure:"discovery_interval"`
+	DiscoveryBroadcast string        `mapstructure:"discovery_broadcast"`
+	DiscoveryTimeout   time.Duration `mapstructure:"discovery_timeout"`
+
+	Devices map[string]DeviceConfig    `mapstructure:"devices"`
+	Groups  map[string]GroupConfig     `mapstructure:"groups"`
+	Scenes  map[string]map[string]bool `mapstructure:"scenes"`
+}
+
+type DeviceConfig struct {
+	Alias  string            `mapstructure:"alias"`
+	Labels map[string]string `mapstructure:"labels"`
+}
+
+// GroupConfig selects devices either by their ID or by matching every
+// label of the selector against the labels declared in DeviceConfig.
+type GroupConfig struct {
+	Devices  []string          `mapstructure:"devices"`
+	Selector map[string]string `mapstructure:"selector"`
 }
 
 func (c Config) IsDev() bool {
 	return c.Env == "dev"
 }
 
+// Aliases returns the alias of every configured device that has one.
+func (c Config) Aliases() map[string]string {
+	aliases := make(map[string]string, len(c.Devices))
+	for id, dev := range c.Devices {
+		if dev.Alias != "" {
+			aliases[id] = dev.Alias
+		}
+	}
+	return aliases
+}
+
 func Get() (*Config, error) {
 	_ = viper.BindEnv("env")
 	_ = viper.BindEnv("log_level")
@@ -25,11 +135,75 @@ func Get() (*Config, error) {
 	viper.SetDefault("listen_address", ":10005")
 	viper.SetDefault("env", "prod")
 	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_device_levels", "")
+	viper.SetDefault("log_sampling_first", 100)
+	viper.SetDefault("log_sampling_thereafter", 100)
+	viper.SetDefault("config_file", "")
+	viper.SetDefault("snapshot_file", "data/snapshot.json")
+	viper.SetDefault("snapshot_interval", "5m")
+	viper.SetDefault("shutdown_timeout", "10s")
+	viper.SetDefault("ready_min_devices", 0)
+	viper.SetDefault("debug_pprof", false)
+	viper.SetDefault("debug_endpoints", false)
+	viper.SetDefault("trace_dir", "")
+	viper.SetDefault("trace_devices", "")
+	viper.SetDefault("trace_max_bytes", 16<<20)
+	viper.SetDefault("trace_max_files", 3)
+	viper.SetDefault("unknown_frames_max", 256)
+	viper.SetDefault("firmware_expected", "")
+	viper.SetDefault("metrics_timestamps", false)
+	viper.SetDefault("metrics_legacy_names", false)
+	viper.SetDefault("metrics_windows", "1m,15m,1h")
+	viper.SetDefault("metrics_power_buckets", "")
+	viper.SetDefault("metrics_current_buckets", "")
+	viper.SetDefault("metrics_unverified_readings", false)
+	viper.SetDefault("command_timeout", "5s")
+	viper.SetDefault("command_retries", 2)
+	viper.SetDefault("ghoma_allowed_networks", "")
+	viper.SetDefault("ghoma_denied_networks", "")
+	viper.SetDefault("ghoma_allowed_devices", "")
+	viper.SetDefault("ghoma_max_connections", 256)
+	viper.SetDefault("ghoma_max_connections_per_ip", 16)
+	viper.SetDefault("ghoma_handshake_timeout", "10s")
+	viper.SetDefault("ghoma_accept_rate", 0)
+	viper.SetDefault("ghoma_accept_burst", 10)
+	viper.SetDefault("history_dir", "")
+	viper.SetDefault("history_retention", "720h")
+	viper.SetDefault("history_resolution", "1m")
+	viper.SetDefault("history_flush_interval", "1m")
+	viper.SetDefault("influx_url", "")
+	viper.SetDefault("influx_org", "")
+	viper.SetDefault("influx_bucket", "")
+	viper.SetDefault("influx_token", "")
+	viper.SetDefault("influx_batch_size", 500)
+	viper.SetDefault("influx_flush_interval", "10s")
+	viper.SetDefault("influx_max_retries", 3)
+	viper.SetDefault("influx_buffer_dir", "")
+	viper.SetDefault("influx_buffer_max_bytes", 64<<20)
+	viper.SetDefault("remote_write_url", "")
+	viper.SetDefault("remote_write_interval", "30s")
+	viper.SetDefault("remote_write_username", "")
+	viper.SetDefault("remote_write_password", "")
+	viper.SetDefault("remote_write_bearer_token", "")
+	viper.SetDefault("remote_write_max_retries", 3)
+	viper.SetDefault("remote_write_wal_dir", "")
+	viper.SetDefault("remote_write_wal_max_bytes", 64<<20)
+	viper.SetDefault("discovery_enabled", false)
+	viper.SetDefault("discovery_interval", "5m")
+	viper.SetDefault("discovery_broadcast", "255.255.255.255")
+	viper.SetDefault("discovery_timeout", "3s")
 
 	if viper.GetString("env") == "dev" {
 		viper.SetDefault("log_level", "debug")
 	}
 
+	if file := viper.GetString("config_file"); file != "" {
+		viper.SetConfigFile(file)
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	}
+
 	conf := &Config{}
 	err := viper.Unmarshal(conf)
 	if err != nil {
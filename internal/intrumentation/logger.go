@@ -1,12 +1,18 @@
 package intrumentation
 
 import (
+	"time"
+
+	"github.com/eliecharra/ghoma/ghoma"
 	"github.com/eliecharra/ghoma/internal/intrumentation/config"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-func InitLogger(config *config.Config) error {
+// InitLogger builds the logger to inject in every component, the levels it
+// returns can be changed at runtime and override the level of single
+// devices. The zap globals are left untouched.
+func InitLogger(config *config.Config) (*zap.Logger, *ghoma.LogLevels, error) {
 	cfg := zap.NewProductionConfig()
 	if config.IsDev() {
 		cfg = zap.NewDevelopmentConfig()
@@ -14,22 +20,32 @@ func InitLogger(config *config.Config) error {
 		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
 
-	level, err := zap.ParseAtomicLevel(config.LogLevel)
+	level, err := zapcore.ParseLevel(config.LogLevel)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	levels := ghoma.NewLogLevels(level)
+	if err := levels.ParseDeviceLevels(config.LogDeviceLevels); err != nil {
+		return nil, nil, err
 	}
-	cfg.Level = level
+	// Levels are checked by the wrapping core
+	cfg.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	// The zap sampler counts entries of every device together, devices are
+	// sampled on their own instead
+	cfg.Sampling = nil
 
-	logger, err := cfg.Build(
+	options := []zap.Option{
 		zap.AddCaller(),
 		zap.AddStacktrace(zap.ErrorLevel),
-	)
+		zap.WrapCore(levels.Core),
+	}
+	if config.LogSamplingFirst > 0 {
+		sampler := ghoma.NewDeviceSampler(time.Second, config.LogSamplingFirst, config.LogSamplingThereafter)
+		options = append(options, zap.WrapCore(sampler.Core))
+	}
+	logger, err := cfg.Build(options...)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	logger.Sugar()
-
-	_ = zap.ReplaceGlobals(logger)
-	return nil
+	return logger, levels, nil
 }
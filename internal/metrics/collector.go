@@ -1,67 +1,189 @@
 package metrics
 
 import (
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 
-	"github.com/eliecharra/ghoma/internal/ghoma"
+	"github.com/eliecharra/ghoma/ghoma"
 	"github.com/eliecharra/ghoma/protocol"
 )
 
 const ns = "ghoma"
 
 type status struct {
-	Switch                                     *float64
-	Power, Energy, Voltage, Current, Frequency float64
-	PowerMax, CosPhi                           float64
+	Switch    *float64 `json:"switch,omitempty"`
+	Power     float64  `json:"power"`
+	Energy    float64  `json:"energy"`
+	Voltage   float64  `json:"voltage"`
+	Current   float64  `json:"current"`
+	Frequency float64  `json:"frequency"`
+	PowerMax  float64  `json:"power_max"`
+	CosPhi    float64  `json:"cos_phi"`
+
+	LastContact map[string]time.Time `json:"last_contact"`
+}
+
+// series is a value exported for every device, kind is the key of the
+// status LastContact map telling when the device reported it.
+type series struct {
+	name       string
+	legacyName string
+	help       string
+	unit       string
+	valueType  prometheus.ValueType
+	kind       string
+	value      func(*status) float64
 
-	LastContact map[string]time.Time
+	desc       *prometheus.Desc
+	legacyDesc *prometheus.Desc
+}
+
+func newSeries() []*series {
+	return []*series{
+		{
+			name:       prometheus.BuildFQName(ns, "switch", "state"),
+			legacyName: prometheus.BuildFQName(ns, "switch", "state"),
+			help:       "reported switch status ( 0 = off, 1 = on)",
+			valueType:  prometheus.GaugeValue,
+			kind:       "switch",
+			value:      func(s *status) float64 { return *s.Switch },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "power", "watts"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "power"),
+			help:       "active power drawn by the load (in watts)",
+			unit:       "watts",
+			valueType:  prometheus.GaugeValue,
+			kind:       "POWER",
+			value:      func(s *status) float64 { return s.Power },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "energy", "kwh_total"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "energy"),
+			help:       "energy consumed by the load as counted by the plug (in kilowatt-hours)",
+			unit:       "kwh",
+			valueType:  prometheus.CounterValue,
+			kind:       "ENERGY",
+			value:      func(s *status) float64 { return s.Energy },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "voltage", "volts"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "voltage"),
+			help:       "power line voltage (in volts)",
+			unit:       "volts",
+			valueType:  prometheus.GaugeValue,
+			kind:       "VOLTAGE",
+			value:      func(s *status) float64 { return s.Voltage },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "frequency", "hertz"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "frequency"),
+			help:       "power line frequency (in hertz)",
+			unit:       "hertz",
+			valueType:  prometheus.GaugeValue,
+			kind:       "FREQUENCY",
+			value:      func(s *status) float64 { return s.Frequency },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "current", "amperes"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "current"),
+			help:       "current drawn by the load (in amperes)",
+			unit:       "amperes",
+			valueType:  prometheus.GaugeValue,
+			kind:       "CURRENT",
+			value:      func(s *status) float64 { return s.Current },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "power_max", "watts"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "power_max"),
+			help:       "maximum active power drawn by the load as recorded by the plug (in watts)",
+			unit:       "watts",
+			valueType:  prometheus.GaugeValue,
+			kind:       "MAX_POWER",
+			value:      func(s *status) float64 { return s.PowerMax },
+		},
+		{
+			name:       prometheus.BuildFQName(ns, "power_factor", "ratio"),
+			legacyName: prometheus.BuildFQName(ns, "energy", "cos_phi"),
+			help:       "power factor of the load (cos phi, from 0 to 1)",
+			unit:       "ratio",
+			valueType:  prometheus.GaugeValue,
+			kind:       "COSPHI",
+			value:      func(s *status) float64 { return s.CosPhi },
+		},
+	}
+}
+
+type Options struct {
+	// Timestamps attaches the time each value was reported by the device to
+	// the exported samples, so stale values are not mistaken for fresh ones.
+	Timestamps bool
+	// LegacyNames also exports values under their names from before units
+	// were added, to migrate dashboards and alerts.
+	LegacyNames bool
+	// Windows are the durations over which rolling min/max/avg of power and
+	// current readings are exported, DefaultWindows when empty.
+	Windows []time.Duration
+	// PowerBuckets and CurrentBuckets are the classic buckets of the readings
+	// histograms, a native histogram is exported as well.
+	PowerBuckets   []float64
+	CurrentBuckets []float64
+	// UnverifiedReadings also exports readings whose scale or sign is not
+	// verified against a capture, see protocol.Measurement.
+	UnverifiedReadings bool
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
 }
 
 type Collector struct {
-	Switch      *prometheus.Desc
-	Power       *prometheus.Desc
-	Energy      *prometheus.Desc
-	Voltage     *prometheus.Desc
-	Frequency   *prometheus.Desc
-	Current     *prometheus.Desc
-	PowerMax    *prometheus.Desc
-	CosPhi      *prometheus.Desc
-	LastContact *prometheus.Desc
+	options       Options
+	series        []*series
+	distributions []*distribution
+	LastContact   *prometheus.Desc
 
 	status map[string]*status
 	mu     sync.RWMutex
 }
 
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.Switch
-	ch <- c.Power
-	ch <- c.Energy
-	ch <- c.Voltage
-	ch <- c.Frequency
-	ch <- c.Current
-	ch <- c.PowerMax
-	ch <- c.CosPhi
+	for _, s := range c.series {
+		ch <- s.desc
+		if s.legacyDesc != nil {
+			ch <- s.legacyDesc
+		}
+	}
+	for _, d := range c.distributions {
+		d.describe(ch)
+	}
 	ch <- c.LastContact
 }
 
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	for device, s := range c.status {
-		labels := []string{device}
-		if s.Switch != nil {
-			ch <- prometheus.MustNewConstMetric(c.Switch, prometheus.GaugeValue, *s.Switch, labels...)
+	now := time.Now()
+	for _, d := range c.distributions {
+		d.collect(ch, c.options.Windows, now)
+	}
+	for key, s := range c.status {
+		labels := keyLabels(key)
+		for _, series := range c.series {
+			t, exist := s.LastContact[series.kind]
+			if !exist {
+				continue
+			}
+			value := series.value(s)
+			ch <- c.metric(series.desc, series.valueType, value, t, labels)
+			if series.legacyDesc != nil && labels[1] == "1" {
+				ch <- c.metric(series.legacyDesc, series.valueType, value, t, labels[:1])
+			}
 		}
-		ch <- prometheus.MustNewConstMetric(c.Power, prometheus.GaugeValue, s.Power, labels...)
-		ch <- prometheus.MustNewConstMetric(c.Energy, prometheus.CounterValue, s.Energy, labels...)
-		ch <- prometheus.MustNewConstMetric(c.Voltage, prometheus.GaugeValue, s.Voltage, labels...)
-		ch <- prometheus.MustNewConstMetric(c.Frequency, prometheus.GaugeValue, s.Frequency, labels...)
-		ch <- prometheus.MustNewConstMetric(c.Current, prometheus.GaugeValue, s.Current, labels...)
-		ch <- prometheus.MustNewConstMetric(c.PowerMax, prometheus.GaugeValue, s.PowerMax, labels...)
-		ch <- prometheus.MustNewConstMetric(c.CosPhi, prometheus.GaugeValue, s.CosPhi, labels...)
 		for k, v := range s.LastContact {
 			labels := append(labels, k)
 			ch <- prometheus.MustNewConstMetric(c.LastContact, prometheus.GaugeValue, time.Since(v).Seconds(), labels...)
@@ -69,16 +191,44 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+func (c *Collector) metric(desc *prometheus.Desc, valueType prometheus.ValueType, value float64, t time.Time, labels []string) prometheus.Metric {
+	m := prometheus.MustNewConstMetric(desc, valueType, value, labels...)
+	if c.options.Timestamps {
+		return prometheus.NewMetricWithTimestamp(t, m)
+	}
+	return m
+}
+
+// units returns the unit of every exported metric family that has one.
+func (c *Collector) units() map[string]string {
+	units := map[string]string{
+		prometheus.BuildFQName(ns, "last_contact", "seconds"): "seconds",
+	}
+	for _, s := range c.series {
+		if s.unit != "" {
+			units[s.name] = s.unit
+		}
+	}
+	for _, d := range c.distributions {
+		for name, unit := range d.units() {
+			units[name] = unit
+		}
+	}
+	return units
+}
+
 func (c *Collector) HandleStatus(dev *ghoma.Device, msg protocol.Message) {
-	var s *status
-	if _, exist := c.status[dev.ID]; !exist {
-		c.mu.Lock()
-		c.status[dev.ID] = &status{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := statusKey(dev.ID, msg.Status.Outlet)
+	s, exist := c.status[key]
+	if !exist {
+		s = &status{
 			LastContact: make(map[string]time.Time, 8),
 		}
-		c.mu.Unlock()
+		c.status[key] = s
 	}
-	s = c.status[dev.ID]
 
 	if msg.Status.Switch != nil {
 		s.LastContact["switch"] = time.Now()
@@ -89,8 +239,18 @@ func (c *Collector) HandleStatus(dev *ghoma.Device, msg protocol.Message) {
 		s.Switch = &val
 	}
 	if msg.Status.Energy != nil {
-		val := float64(msg.Status.Energy.Value()) / 100
-		s.LastContact[msg.Status.Energy.Kind()] = time.Now()
+		m := msg.Status.Energy.Measurement()
+		if !m.Verified && !c.options.UnverifiedReadings {
+			return
+		}
+		val := m.Value
+		now := time.Now()
+		s.LastContact[msg.Status.Energy.Kind()] = now
+		for _, d := range c.distributions {
+			if d.kind == msg.Status.Energy.Kind() {
+				d.add(key, now, val, c.keep())
+			}
+		}
 		switch msg.Status.Energy.Kind() {
 		case "POWER":
 			s.Power = val
@@ -110,65 +270,136 @@ func (c *Collector) HandleStatus(dev *ghoma.Device, msg protocol.Message) {
 	}
 }
 
-func NewCollector() *Collector {
-	labels := []string{"device"}
-	return &Collector{
+// Readings is a copy of the latest values reported by a device.
+type Readings struct {
+	Switch                                     *bool
+	Power, Energy, Voltage, Current, Frequency float64
+	PowerMax, CosPhi                           float64
+	LastContact                                time.Time
+}
+
+// Readings returns the latest values reported by the first outlet of the
+// given device.
+func (c *Collector) Readings(id string) (Readings, bool) {
+	return c.OutletReadings(id, 1)
+}
+
+// OutletReadings returns the latest values reported by an outlet of the
+// given device.
+func (c *Collector) OutletReadings(id string, outlet int) (Readings, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s, exist := c.status[statusKey(id, outlet)]
+	if !exist {
+		return Readings{}, false
+	}
+
+	r := Readings{
+		Power:     s.Power,
+		Energy:    s.Energy,
+		Voltage:   s.Voltage,
+		Current:   s.Current,
+		Frequency: s.Frequency,
+		PowerMax:  s.PowerMax,
+		CosPhi:    s.CosPhi,
+	}
+	if s.Switch != nil {
+		on := *s.Switch == 1
+		r.Switch = &on
+	}
+	for _, t := range s.LastContact {
+		if t.After(r.LastContact) {
+			r.LastContact = t
+		}
+	}
+	return r, true
+}
+
+// Outlets returns the outlets of the given device with readings, in order.
+func (c *Collector) Outlets(id string) []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var outlets []int
+	for key := range c.status {
+		labels := keyLabels(key)
+		if labels[0] != id {
+			continue
+		}
+		if outlet, err := strconv.Atoi(labels[1]); err == nil {
+			outlets = append(outlets, outlet)
+		}
+	}
+	sort.Ints(outlets)
+	return outlets
+}
+
+// statusKey identifies the values of an outlet. The first outlet is keyed
+// by the device ID alone, as it was before outlets were told apart, so
+// snapshots of single outlet plugs stay valid.
+func statusKey(device string, outlet int) string {
+	if outlet <= 1 {
+		return device
+	}
+	return device + "/" + strconv.Itoa(outlet)
+}
+
+// keyLabels returns the device and outlet labels of a status key.
+func keyLabels(key string) []string {
+	device, outlet, found := strings.Cut(key, "/")
+	if !found {
+		outlet = "1"
+	}
+	return []string{device, outlet}
+}
+
+// keep is the longest window, readings older than it are discarded.
+func (c *Collector) keep() time.Duration {
+	var keep time.Duration
+	for _, w := range c.options.Windows {
+		keep = max(keep, w)
+	}
+	return keep
+}
+
+func NewCollector(options Options) *Collector {
+	if len(options.Windows) == 0 {
+		options.Windows = DefaultWindows
+	}
+	if len(options.PowerBuckets) == 0 {
+		options.PowerBuckets = DefaultPowerBuckets
+	}
+	if len(options.CurrentBuckets) == 0 {
+		options.CurrentBuckets = DefaultCurrentBuckets
+	}
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+	labels := []string{"device", "outlet"}
+	c := &Collector{
+		options: options,
+		series:  newSeries(),
+		distributions: []*distribution{
+			newDistribution("POWER", "power", "watts", options.PowerBuckets),
+			newDistribution("CURRENT", "current", "amperes", options.CurrentBuckets),
+		},
 		status: make(map[string]*status),
 		mu:     sync.RWMutex{},
-		Switch: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "switch", "state"),
-			"reported switch status ( 0 = off, 1 = on)",
-			labels,
-			nil,
-		),
-		Power: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "power"),
-			"", // TODO
-			labels,
-			nil,
-		),
-		Energy: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "energy"),
-			"", // TODO
-			labels,
-			nil,
-		),
-		Voltage: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "voltage"),
-			"", // TODO
-			labels,
-			nil,
-		),
-		Frequency: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "frequency"),
-			"power line frequency (in hertz)",
-			labels,
-			nil,
-		),
-		Current: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "current"),
-			"current in ampere",
-			labels,
-			nil,
-		),
-		PowerMax: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "power_max"),
-			"", // TODO
-			labels,
-			nil,
-		),
-		CosPhi: prometheus.NewDesc(
-			prometheus.BuildFQName(ns, "energy", "cos_phi"),
-			"", // TODO
-			labels,
-			nil,
-		),
-
 		LastContact: prometheus.NewDesc(
 			prometheus.BuildFQName(ns, "last_contact", "seconds"),
-			"last timestamp data were updated", // TODO
+			"seconds elapsed since the device last reported each kind of data",
 			append(labels, "metric_kind"),
 			nil,
 		),
 	}
+	for _, s := range c.series {
+		s.desc = prometheus.NewDesc(s.name, s.help, labels, nil)
+		if options.LegacyNames && s.legacyName != s.name {
+			// Legacy series keep their original labels, so only the first
+			// outlet is exported under them.
+			s.legacyDesc = prometheus.NewDesc(s.legacyName, s.help+" (deprecated, use "+s.name+")", labels[:1], nil)
+		}
+	}
+	return c
 }
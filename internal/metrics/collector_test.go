@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+var powerFrame = []byte{
+	0x90, 0x01, 0x0a, 0xe0, 0x32, 0x23, 0xd7, 0x8a, 0x1c,
+	0xff, 0xfe, 0x01, 0x81, 0x39, 0x00, 0x00, 0x01,
+	0x01, 0x00, 0x00, 0x11, 0x34,
+}
+
+func scrape(t *testing.T, c *Collector, accept string) string {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(c))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	rec := httptest.NewRecorder()
+	c.Handler(registry).ServeHTTP(rec, req)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	var lines []string
+	for _, l := range strings.Split(string(body), "\n") {
+		if strings.HasPrefix(l, "ghoma_last_contact") || strings.HasPrefix(l, "# HELP ghoma_last_contact") {
+			continue
+		}
+		// Distributions are covered by TestCollector_Windows
+		if strings.Contains(l, "_readings_") || strings.Contains(l, "_window_") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCollector_OpenMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		accept  string
+		want    string
+	}{
+		{
+			name:   "text format",
+			accept: "text/plain",
+			want: `# TYPE ghoma_last_contact_seconds gauge
+# HELP ghoma_power_watts active power drawn by the load (in watts)
+# TYPE ghoma_power_watts gauge
+ghoma_power_watts{device="d78a1c",outlet="1"} 44.04
+`,
+		},
+		{
+			name:    "openmetrics with timestamps and legacy names",
+			options: Options{Timestamps: true, LegacyNames: true},
+			accept:  "application/openmetrics-text; version=1.0.0",
+			want: `# HELP ghoma_energy_power active power drawn by the load (in watts) (deprecated, use ghoma_power_watts)
+# TYPE ghoma_energy_power gauge
+ghoma_energy_power{device="d78a1c"} 44.04 1.6961112e+09
+# TYPE ghoma_last_contact_seconds gauge
+# UNIT ghoma_last_contact_seconds seconds
+# HELP ghoma_power_watts active power drawn by the load (in watts)
+# TYPE ghoma_power_watts gauge
+# UNIT ghoma_power_watts watts
+ghoma_power_watts{device="d78a1c",outlet="1"} 44.04 1.6961112e+09
+# EOF
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(tt.options)
+			c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(powerFrame))
+			c.status["d78a1c"].LastContact["POWER"] = time.Unix(1696111200, 0)
+
+			require.Equal(t, tt.want, scrape(t, c, tt.accept))
+		})
+	}
+}
+
+func TestCollector_UnverifiedReadings(t *testing.T) {
+	voltageFrame := append([]byte{}, powerFrame...)
+	voltageFrame[17], voltageFrame[20], voltageFrame[21] = 0x03, 0x59, 0x8d
+
+	tests := []struct {
+		name    string
+		options Options
+		want    float64
+	}{
+		{name: "left out", want: 0},
+		{name: "exported on demand", options: Options{UnverifiedReadings: true}, want: 229.25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCollector(tt.options)
+			c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(powerFrame))
+			c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(voltageFrame))
+
+			r, ok := c.Readings("d78a1c")
+			require.True(t, ok)
+			require.Equal(t, 44.04, r.Power)
+			require.Equal(t, tt.want, r.Voltage)
+		})
+	}
+}
+
+func TestCollector_Windows(t *testing.T) {
+	c := NewCollector(Options{Windows: []time.Duration{time.Minute, time.Hour}})
+	d := c.distributions[0]
+	now := time.Now()
+	readings := []struct {
+		age   time.Duration
+		value float64
+	}{
+		{age: 2 * time.Hour, value: 1000},
+		{age: 30 * time.Minute, value: 10},
+		{age: 10 * time.Minute, value: 40},
+		{age: 30 * time.Second, value: 20},
+		{age: 10 * time.Second, value: 30},
+	}
+	for _, r := range readings {
+		d.add("d78a1c", now.Add(-r.age), r.value, c.keep())
+	}
+
+	require.Len(t, d.readings["d78a1c"], 4, "readings older than the longest window are discarded")
+
+	min, max, avg, ok := d.stats("d78a1c", now.Add(-time.Minute))
+	require.True(t, ok)
+	require.Equal(t, []float64{20, 30, 25}, []float64{min, max, avg})
+
+	min, max, avg, ok = d.stats("d78a1c", now.Add(-time.Hour))
+	require.True(t, ok)
+	require.Equal(t, []float64{10, 40, 25}, []float64{min, max, avg})
+
+	_, _, _, ok = d.stats("unknown", now.Add(-time.Hour))
+	require.False(t, ok)
+}
+
+func TestWindowLabel(t *testing.T) {
+	require.Equal(t, "1m", windowLabel(time.Minute))
+	require.Equal(t, "15m", windowLabel(15*time.Minute))
+	require.Equal(t, "1h", windowLabel(time.Hour))
+	require.Equal(t, "1h30m", windowLabel(90*time.Minute))
+	require.Equal(t, "45s", windowLabel(45*time.Second))
+}
+
+func TestCollector_Snapshot(t *testing.T) {
+	c := NewCollector(Options{})
+	c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(powerFrame))
+	data, err := c.Snapshot()
+	require.NoError(t, err)
+
+	restored := NewCollector(Options{})
+	require.NoError(t, restored.Restore(data))
+
+	readings, exist := restored.Readings("d78a1c")
+	require.True(t, exist)
+	require.Equal(t, 44.04, readings.Power)
+	require.WithinDuration(t, c.status["d78a1c"].LastContact["POWER"], readings.LastContact, 0)
+	require.Equal(t, c.distributions[0].readings["d78a1c"][0].Value, restored.distributions[0].readings["d78a1c"][0].Value)
+}
+
+func TestCollector_Outlets(t *testing.T) {
+	stripPower := append([]byte{}, powerFrame...)
+	stripPower[11], stripPower[16] = 0x02, 0x03
+
+	c := NewCollector(Options{LegacyNames: true})
+	c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(powerFrame))
+	c.HandleStatus(&ghoma.Device{ID: "d78a1c"}, *protocol.MustParse(stripPower))
+
+	require.Equal(t, `# HELP ghoma_energy_power active power drawn by the load (in watts) (deprecated, use ghoma_power_watts)
+# TYPE ghoma_energy_power gauge
+ghoma_energy_power{device="d78a1c"} 44.04
+# TYPE ghoma_last_contact_seconds gauge
+# HELP ghoma_power_watts active power drawn by the load (in watts)
+# TYPE ghoma_power_watts gauge
+ghoma_power_watts{device="d78a1c",outlet="1"} 44.04
+ghoma_power_watts{device="d78a1c",outlet="3"} 44.04
+`, scrape(t, c, "text/plain"))
+
+	_, exist := c.OutletReadings("d78a1c", 3)
+	require.True(t, exist)
+	_, exist = c.OutletReadings("d78a1c", 2)
+	require.False(t, exist)
+
+	samples := c.Samples()
+	require.Len(t, samples, 3)
+	require.Equal(t, map[string]string{"device": "d78a1c"}, samples[0].Labels)
+	require.Equal(t, map[string]string{"device": "d78a1c", "outlet": "3"}, samples[2].Labels)
+}
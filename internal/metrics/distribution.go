@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	DefaultWindows        = []time.Duration{time.Minute, 15 * time.Minute, time.Hour}
+	DefaultPowerBuckets   = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2000, 3500}
+	DefaultCurrentBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 16}
+)
+
+// distribution tracks every reading of an energy kind, both in a histogram
+// and in rolling windows for min/max/avg statistics.
+type distribution struct {
+	kind      string
+	subsystem string
+	histogram *prometheus.HistogramVec
+	min       *prometheus.Desc
+	max       *prometheus.Desc
+	avg       *prometheus.Desc
+	unit      string
+
+	// readings per status key, oldest first, kept for the longest window
+	readings map[string][]reading
+}
+
+type reading struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+func newDistribution(kind, subsystem, unit string, buckets []float64) *distribution {
+	labels := []string{"device", "outlet", "window"}
+	return &distribution{
+		kind:      kind,
+		subsystem: subsystem,
+		unit:      unit,
+		histogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:                      ns,
+			Subsystem:                      subsystem,
+			Name:                           "readings_" + unit,
+			Help:                           "distribution of the " + subsystem + " readings reported by the device (in " + unit + ")",
+			Buckets:                        buckets,
+			NativeHistogramBucketFactor:    1.1,
+			NativeHistogramMaxBucketNumber: 100,
+		}, []string{"device", "outlet"}),
+		min: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "window_min_"+unit),
+			"lowest "+subsystem+" reading over the window (in "+unit+")",
+			labels, nil,
+		),
+		max: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "window_max_"+unit),
+			"highest "+subsystem+" reading over the window (in "+unit+")",
+			labels, nil,
+		),
+		avg: prometheus.NewDesc(
+			prometheus.BuildFQName(ns, subsystem, "window_avg_"+unit),
+			"average "+subsystem+" reading over the window (in "+unit+")",
+			labels, nil,
+		),
+		readings: make(map[string][]reading),
+	}
+}
+
+func (d *distribution) add(key string, t time.Time, value float64, keep time.Duration) {
+	d.histogram.WithLabelValues(keyLabels(key)...).Observe(value)
+
+	readings := append(d.readings[key], reading{Time: t, Value: value})
+	i := sort.Search(len(readings), func(i int) bool {
+		return t.Sub(readings[i].Time) <= keep
+	})
+	d.readings[key] = append(readings[:0], readings[i:]...)
+}
+
+// stats returns the min, max and average of the readings of the outlet more
+// recent than since, ok is false when there is none.
+func (d *distribution) stats(key string, since time.Time) (min, max, avg float64, ok bool) {
+	min, max = math.Inf(1), math.Inf(-1)
+	var sum float64
+	var count int
+	for _, r := range d.readings[key] {
+		if r.Time.Before(since) {
+			continue
+		}
+		min = math.Min(min, r.Value)
+		max = math.Max(max, r.Value)
+		sum += r.Value
+		count++
+	}
+	if count == 0 {
+		return 0, 0, 0, false
+	}
+	return min, max, sum / float64(count), true
+}
+
+func (d *distribution) describe(ch chan<- *prometheus.Desc) {
+	d.histogram.Describe(ch)
+	ch <- d.min
+	ch <- d.max
+	ch <- d.avg
+}
+
+func (d *distribution) collect(ch chan<- prometheus.Metric, windows []time.Duration, now time.Time) {
+	d.histogram.Collect(ch)
+	for key := range d.readings {
+		for _, w := range windows {
+			min, max, avg, ok := d.stats(key, now.Add(-w))
+			if !ok {
+				continue
+			}
+			labels := append(keyLabels(key), windowLabel(w))
+			ch <- prometheus.MustNewConstMetric(d.min, prometheus.GaugeValue, min, labels...)
+			ch <- prometheus.MustNewConstMetric(d.max, prometheus.GaugeValue, max, labels...)
+			ch <- prometheus.MustNewConstMetric(d.avg, prometheus.GaugeValue, avg, labels...)
+		}
+	}
+}
+
+func (d *distribution) units() map[string]string {
+	units := make(map[string]string, 4)
+	for _, stat := range []string{"readings", "window_min", "window_max", "window_avg"} {
+		units[prometheus.BuildFQName(ns, d.subsystem, stat+"_"+d.unit)] = d.unit
+	}
+	return units
+}
+
+// windowLabel formats a window without its trailing zero units, 1h rather
+// than 1h0m0s.
+func windowLabel(w time.Duration) string {
+	s := w.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// Handler serves the metrics gathered by g like promhttp does, adding the
+// unit of the collector series to the OpenMetrics output. promhttp cannot
+// do it as client_golang never sets units on metric families.
+func (c *Collector) Handler(g prometheus.Gatherer) http.Handler {
+	units := c.units()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := g.Gather()
+		if err != nil {
+			// Keep serving what was gathered, like promhttp.ContinueOnError
+			c.options.Logger.Error("error gathering metrics", zap.Error(err))
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		var options []expfmt.EncoderOption
+		if format.FormatType() == expfmt.TypeOpenMetrics {
+			options = append(options, expfmt.WithUnit())
+			for _, f := range families {
+				if unit, exist := units[f.GetName()]; exist {
+					unit := unit
+					f.Unit = &unit
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format, options...)
+		for _, f := range families {
+			if err := enc.Encode(f); err != nil {
+				c.options.Logger.Error("error encoding metric family", zap.String("family", f.GetName()), zap.Error(err))
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			if err := closer.Close(); err != nil {
+				c.options.Logger.Error("error closing metrics encoder", zap.Error(err))
+			}
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+)
+
+// Sample is the latest value of a collector series, timestamped with the
+// time the device reported it rather than the time it is read.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Time   time.Time
+}
+
+// Samples returns the latest value of every series with a known report
+// time, sorted by name, device then outlet.
+func (c *Collector) Samples() []Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var samples []Sample
+	for key, s := range c.status {
+		labels := keyLabels(key)
+		for _, series := range c.series {
+			t, exist := s.LastContact[series.kind]
+			if !exist {
+				continue
+			}
+			samples = append(samples, Sample{
+				Name:   series.name,
+				Labels: map[string]string{"device": labels[0], "outlet": labels[1]},
+				Value:  series.value(s),
+				Time:   t,
+			})
+			if series.legacyDesc != nil && labels[1] == "1" {
+				samples = append(samples, Sample{
+					Name:   series.legacyName,
+					Labels: map[string]string{"device": labels[0]},
+					Value:  series.value(s),
+					Time:   t,
+				})
+			}
+		}
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].Name != samples[j].Name {
+			return samples[i].Name < samples[j].Name
+		}
+		if samples[i].Labels["device"] != samples[j].Labels["device"] {
+			return samples[i].Labels["device"] < samples[j].Labels["device"]
+		}
+		return samples[i].Labels["outlet"] < samples[j].Labels["outlet"]
+	})
+	return samples
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// state is the collector state kept across restarts, readings of the
+// distributions are keyed by kind then device, see statusKey.
+type state struct {
+	Devices  map[string]*status              `json:"devices"`
+	Readings map[string]map[string][]reading `json:"readings"`
+}
+
+// Snapshot returns the latest values and window readings of every device,
+// to be given to Restore after a restart.
+func (c *Collector) Snapshot() (json.RawMessage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	st := state{
+		Devices:  c.status,
+		Readings: make(map[string]map[string][]reading, len(c.distributions)),
+	}
+	for _, d := range c.distributions {
+		st.Readings[d.kind] = d.readings
+	}
+	return json.Marshal(st)
+}
+
+// Restore loads a snapshot, values reported since the collector was created
+// are kept over the restored ones. Histograms are not part of snapshots.
+func (c *Collector) Restore(data json.RawMessage) error {
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, s := range st.Devices {
+		if _, exist := c.status[id]; exist || s == nil {
+			continue
+		}
+		if s.LastContact == nil {
+			s.LastContact = make(map[string]time.Time, 8)
+		}
+		c.status[id] = s
+	}
+	for _, d := range c.distributions {
+		for id, readings := range st.Readings[d.kind] {
+			if _, exist := d.readings[id]; !exist {
+				d.readings[id] = readings
+			}
+		}
+	}
+	return nil
+}
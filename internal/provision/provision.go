@@ -0,0 +1,191 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Port is the UDP port plugs listen on for configuration.
+const Port = 48899
+
+// hello is the discovery password of the Wi-Fi module, plugs answer it with
+// their address and enter command mode once it is acknowledged.
+const hello = "HF-A11ASSISTHREAD"
+
+var (
+	ErrTimeout = errors.New("no answer from plug")
+	ErrCommand = errors.New("command rejected by plug")
+)
+
+type Options struct {
+	// Broadcast is the address discovery is sent to.
+	Broadcast string
+	// Port is the configuration port of the plugs.
+	Port int
+	// Timeout is how long to wait for an answer, commands are sent again up
+	// to Retries times.
+	Timeout time.Duration
+	Retries int
+	// DryRun logs the commands changing the plug configuration instead of
+	// sending them, queries are still sent.
+	DryRun bool
+	Logger *zap.Logger
+}
+
+func (o *Options) defaults() {
+	if o.Broadcast == "" {
+		o.Broadcast = "255.255.255.255"
+	}
+	if o.Port == 0 {
+		o.Port = Port
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+}
+
+// Plug is a plug that answered the discovery.
+type Plug struct {
+	IP     string `json:"ip"`
+	MAC    string `json:"mac"`
+	Module string `json:"module"`
+}
+
+// ID is the device ID the plug registers with on the ghoma server, the end
+// of its MAC address.
+func (p Plug) ID() string {
+	mac := strings.ToLower(p.MAC)
+	if len(mac) < 6 {
+		return mac
+	}
+	return mac[len(mac)-6:]
+}
+
+func parsePlug(reply string) (Plug, error) {
+	fields := strings.Split(strings.TrimSpace(reply), ",")
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return Plug{}, fmt.Errorf("unexpected discovery answer %q", reply)
+	}
+	p := Plug{IP: fields[0], MAC: fields[1]}
+	if len(fields) > 2 {
+		p.Module = fields[2]
+	}
+	return p, nil
+}
+
+// Discover broadcasts the discovery and returns every plug answering before
+// the timeout.
+func Discover(ctx context.Context, options Options) ([]Plug, error) {
+	options.defaults()
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(options.Broadcast, strconv.Itoa(options.Port)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP([]byte(hello), addr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(options.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var plugs []Plug
+	buf := make([]byte, 512)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return plugs, nil
+			}
+			return plugs, err
+		}
+		p, err := parsePlug(string(buf[:n]))
+		if err != nil {
+			options.Logger.Debug("ignoring answer", zap.Error(err))
+			continue
+		}
+		if !seen[p.MAC] {
+			seen[p.MAC] = true
+			plugs = append(plugs, p)
+		}
+	}
+}
+
+// Request is the configuration to apply to a plug, Wi-Fi settings are left
+// untouched when SSID is empty and Host defaults to the address of this host
+// on the network of the plug.
+type Request struct {
+	SSID     string
+	Password string
+	Host     string
+	Port     int
+	// Restart reboots the plug when its configuration changed.
+	Restart bool
+}
+
+type Result struct {
+	Plug     Plug   `json:"plug"`
+	Previous Server `json:"previous"`
+	Server   Server `json:"server"`
+	Changed  bool   `json:"changed"`
+}
+
+// Provision points the plug at the given IP to the ghoma server, and joins
+// it to a Wi-Fi network when asked to.
+func Provision(ip string, req Request, options Options) (Result, error) {
+	s, err := Open(ip, options)
+	if err != nil {
+		return Result{}, err
+	}
+	defer s.Close()
+
+	res := Result{Plug: s.Plug}
+	if res.Previous, err = s.Server(); err != nil {
+		return res, err
+	}
+
+	host := req.Host
+	if host == "" {
+		host = s.LocalIP()
+	}
+	res.Server = Server{Protocol: "TCP", Mode: "Client", Host: host, Port: req.Port}
+	if res.Server != res.Previous {
+		if err := s.SetServer(host, req.Port); err != nil {
+			return res, err
+		}
+		res.Changed = true
+	}
+	if req.SSID != "" {
+		if err := s.SetWiFi(req.SSID, req.Password); err != nil {
+			return res, err
+		}
+		res.Changed = true
+	}
+
+	if res.Changed && req.Restart {
+		return res, s.Restart()
+	}
+	return res, nil
+}
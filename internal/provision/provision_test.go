@@ -0,0 +1,113 @@
+package provision
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/internal/provision/provisiontest"
+)
+
+func newPlug(t *testing.T) (*provisiontest.Plug, Options) {
+	plug, err := provisiontest.NewPlug("ACCF23D78A1C")
+	require.NoError(t, err)
+	t.Cleanup(func() { plug.Close() })
+	return plug, Options{Broadcast: "127.0.0.1", Port: plug.Port(), Timeout: 200 * time.Millisecond}
+}
+
+func TestDiscover(t *testing.T) {
+	_, options := newPlug(t)
+
+	plugs, err := Discover(context.Background(), options)
+	require.NoError(t, err)
+	require.Equal(t, []Plug{{IP: "127.0.0.1", MAC: "ACCF23D78A1C", Module: "HF-LPB100"}}, plugs)
+	require.Equal(t, "d78a1c", plugs[0].ID())
+}
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name             string
+		request          Request
+		dryRun           bool
+		expectedChanged  bool
+		expectedCommands []string
+		expectedNETP     string
+	}{
+		{
+			name:             "server and wifi",
+			request:          Request{SSID: "home", Password: "secret", Host: "192.168.1.20", Port: 4196, Restart: true},
+			expectedChanged:  true,
+			expectedCommands: []string{"NETP", "NETP=TCP,Client,4196,192.168.1.20", "WSSSID=home", "WSKEY=WPA2PSK,AES,secret", "WMODE=STA", "Z", "Q"},
+			expectedNETP:     "TCP,Client,4196,192.168.1.20",
+		},
+		{
+			name:             "already provisioned",
+			request:          Request{Host: "192.168.1.2", Port: 4196, Restart: true},
+			expectedCommands: []string{"NETP", "Q"},
+			expectedNETP:     "TCP,Client,4196,192.168.1.2",
+		},
+		{
+			name:             "default host",
+			request:          Request{Port: 4196},
+			expectedChanged:  true,
+			expectedCommands: []string{"NETP", "NETP=TCP,Client,4196,127.0.0.1", "Q"},
+			expectedNETP:     "TCP,Client,4196,127.0.0.1",
+		},
+		{
+			name:             "dry run",
+			request:          Request{SSID: "home", Password: "secret", Host: "192.168.1.20", Port: 4196, Restart: true},
+			dryRun:           true,
+			expectedChanged:  true,
+			expectedCommands: []string{"NETP", "Q"},
+			expectedNETP:     "TCP,Client,4196,192.168.1.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plug, options := newPlug(t)
+			options.DryRun = tt.dryRun
+
+			res, err := Provision("127.0.0.1", tt.request, options)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedChanged, res.Changed)
+			require.Equal(t, Server{Protocol: "TCP", Mode: "Client", Host: "192.168.1.2", Port: 4196}, res.Previous)
+
+			require.Eventually(t, func() bool {
+				return len(plug.Commands()) == len(tt.expectedCommands)
+			}, time.Second, 10*time.Millisecond)
+			require.Equal(t, tt.expectedCommands, plug.Commands())
+			require.Equal(t, tt.expectedNETP, plug.Setting("NETP"))
+		})
+	}
+}
+
+func TestSession_Command(t *testing.T) {
+	_, options := newPlug(t)
+	s, err := Open("127.0.0.1", options)
+	require.NoError(t, err)
+	defer s.Close()
+
+	value, err := s.Command("WMODE")
+	require.NoError(t, err)
+	require.Equal(t, "AP", value)
+
+	_, err = s.Command("UNKNOWN")
+	require.ErrorIs(t, err, ErrCommand)
+}
+
+func TestRedact(t *testing.T) {
+	require.Equal(t, "WSKEY=<redacted>", redact("WSKEY=WPA2PSK,AES,secret"))
+	require.Equal(t, "WSKEY", redact("WSKEY"))
+	require.Equal(t, "WSSSID=home", redact("WSSSID=home"))
+}
+
+func TestSession_Timeout(t *testing.T) {
+	plug, options := newPlug(t)
+	plug.Close()
+	options.Retries = 1
+
+	_, err := Open("127.0.0.1", options)
+	require.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Package provisiontest provides a UDP stand-in for the configuration port
+// of a plug.
+package provisiontest
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// Plug answers discovery and AT commands like the Wi-Fi module of a plug,
+// keeping the configuration it is given in memory.
+type Plug struct {
+	MAC  string
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	settings map[string]string
+	commands []string
+}
+
+// NewPlug starts a plug listening on a random local port, to be used as the
+// port of provision.Options.
+func NewPlug(mac string) (*Plug, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	p := &Plug{
+		MAC:  mac,
+		conn: conn,
+		settings: map[string]string{
+			"NETP":  "TCP,Client,4196,192.168.1.2",
+			"WMODE": "AP",
+		},
+	}
+	go p.serve()
+	return p, nil
+}
+
+func (p *Plug) Port() int {
+	return p.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func (p *Plug) Close() error {
+	return p.conn.Close()
+}
+
+// Setting returns the value of a setting, as set by an AT command.
+func (p *Plug) Setting(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.settings[name]
+}
+
+// Commands returns the AT commands received, without their AT+ prefix.
+func (p *Plug) Commands() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.commands...)
+}
+
+func (p *Plug) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if reply := p.handle(string(buf[:n])); reply != "" {
+			_, _ = p.conn.WriteToUDP([]byte(reply), addr)
+		}
+	}
+}
+
+func (p *Plug) handle(msg string) string {
+	if msg == "HF-A11ASSISTHREAD" {
+		return "127.0.0.1," + p.MAC + ",HF-LPB100"
+	}
+	if !strings.HasPrefix(msg, "AT+") {
+		return ""
+	}
+
+	cmd := strings.TrimSuffix(strings.TrimPrefix(msg, "AT+"), "\r")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands = append(p.commands, cmd)
+
+	name, value, set := strings.Cut(cmd, "=")
+	switch {
+	case name == "Z":
+		return ""
+	case name == "Q":
+		return "+ok\r\n\r\n"
+	case set:
+		p.settings[name] = value
+		return "+ok\r\n\r\n"
+	default:
+		if value, exist := p.settings[name]; exist {
+			return "+ok=" + value + "\r\n\r\n"
+		}
+		return "+ERR=-2\r\n\r\n"
+	}
+}
@@ -0,0 +1,181 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Session is a command mode session with a single plug.
+type Session struct {
+	Plug    Plug
+	conn    *net.UDPConn
+	options Options
+	logger  *zap.Logger
+}
+
+// Open enters the command mode of the plug at the given IP.
+func Open(ip string, options Options) (*Session, error) {
+	options.defaults()
+	addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(ip, strconv.Itoa(options.Port)))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		conn:    conn,
+		options: options,
+		logger:  options.Logger.With(zap.String("plug", ip)),
+	}
+
+	reply, err := s.exchange(hello)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.Plug, err = parsePlug(reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The acknowledgement gets no answer
+	if _, err := conn.Write([]byte("+ok")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.logger.Debug("command mode entered", zap.String("mac", s.Plug.MAC))
+	return s, nil
+}
+
+// LocalIP is the address of this host on the network of the plug.
+func (s *Session) LocalIP() string {
+	return s.conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// exchange sends a message and returns the first answer, sending it again
+// when none comes before the timeout.
+func (s *Session) exchange(msg string) (string, error) {
+	buf := make([]byte, 512)
+	for attempt := 0; attempt <= s.options.Retries; attempt++ {
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			return "", err
+		}
+		if err := s.conn.SetReadDeadline(time.Now().Add(s.options.Timeout)); err != nil {
+			return "", err
+		}
+		n, err := s.conn.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+	return "", ErrTimeout
+}
+
+// Command sends an AT command, without its AT+ prefix, and returns the value
+// of the answer.
+func (s *Session) Command(cmd string) (string, error) {
+	if s.options.DryRun && strings.Contains(cmd, "=") {
+		s.logger.Info("dry run, not sending", zap.String("command", "AT+"+redact(cmd)))
+		return "", nil
+	}
+	reply, err := s.exchange("AT+" + cmd + "\r")
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	s.logger.Debug("command", zap.String("command", "AT+"+redact(cmd)), zap.String("reply", reply))
+	switch {
+	case reply == "+ok":
+		return "", nil
+	case strings.HasPrefix(reply, "+ok="):
+		return strings.TrimPrefix(reply, "+ok="), nil
+	default:
+		return "", fmt.Errorf("%w: AT+%s: %s", ErrCommand, redact(cmd), reply)
+	}
+}
+
+// secretCommands are the commands whose value is never logged.
+var secretCommands = map[string]bool{"WSKEY": true}
+
+// redact hides the value of a command setting a secret, e.g. the Wi-Fi key.
+func redact(cmd string) string {
+	if name, _, found := strings.Cut(cmd, "="); found && secretCommands[name] {
+		return name + "=<redacted>"
+	}
+	return cmd
+}
+
+// Server is the TCP server a plug connects to.
+type Server struct {
+	Protocol string `json:"protocol"`
+	Mode     string `json:"mode"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+}
+
+func (s Server) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Server reads the server the plug connects to.
+func (s *Session) Server() (Server, error) {
+	value, err := s.Command("NETP")
+	if err != nil {
+		return Server{}, err
+	}
+	fields := strings.Split(value, ",")
+	if len(fields) != 4 {
+		return Server{}, fmt.Errorf("unexpected server %q", value)
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return Server{}, fmt.Errorf("unexpected server port %q", fields[2])
+	}
+	return Server{Protocol: fields[0], Mode: fields[1], Port: port, Host: fields[3]}, nil
+}
+
+// SetServer points the plug to a TCP server, effective after a restart.
+func (s *Session) SetServer(host string, port int) error {
+	_, err := s.Command(fmt.Sprintf("NETP=TCP,Client,%d,%s", port, host))
+	return err
+}
+
+// SetWiFi joins the plug to a WPA2 network, effective after a restart.
+func (s *Session) SetWiFi(ssid, password string) error {
+	if _, err := s.Command("WSSSID=" + ssid); err != nil {
+		return err
+	}
+	if _, err := s.Command("WSKEY=WPA2PSK,AES," + password); err != nil {
+		return err
+	}
+	_, err := s.Command("WMODE=STA")
+	return err
+}
+
+// Restart reboots the plug to apply the configuration, it does not answer.
+func (s *Session) Restart() error {
+	if s.options.DryRun {
+		s.logger.Info("dry run, not sending", zap.String("command", "AT+Z"))
+		return nil
+	}
+	_, err := s.conn.Write([]byte("AT+Z\r"))
+	return err
+}
+
+// Close leaves the command mode.
+func (s *Session) Close() error {
+	_, _ = s.conn.Write([]byte("AT+Q\r"))
+	return s.conn.Close()
+}
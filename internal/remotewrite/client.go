@@ -0,0 +1,253 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/internal/metrics"
+)
+
+type Options struct {
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// Username and Password enable basic auth, BearerToken bearer auth.
+	Username    string
+	Password    string
+	BearerToken string
+
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// WALDir holds write requests that could not be sent, up to WALMaxBytes
+	// (64 MiB when zero), oldest requests are deleted past it. Requests are
+	// dropped when WALDir is empty.
+	WALDir      string
+	WALMaxBytes int64
+
+	// Logger defaults to a no-op logger.
+	Logger *zap.Logger
+}
+
+type sampler interface {
+	Samples() []metrics.Sample
+}
+
+// permanentError is returned for rejected requests that must not be retried.
+type permanentError struct {
+	status int
+	body   string
+}
+
+func (e permanentError) Error() string {
+	return fmt.Sprintf("remote write rejected with status %d: %s", e.status, e.body)
+}
+
+// Client pushes the collector samples to a Prometheus remote write endpoint.
+// Only samples reported since the previous push are sent.
+type Client struct {
+	options Options
+	source  sampler
+	client  *http.Client
+	wal     *wal
+	logger  *zap.Logger
+	desc    *prometheus.Desc
+
+	sent map[string]time.Time
+}
+
+func NewClient(source sampler, options Options) (*Client, error) {
+	if options.Interval <= 0 {
+		options.Interval = 30 * time.Second
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = time.Second
+	}
+	if options.WALMaxBytes <= 0 {
+		options.WALMaxBytes = 64 << 20
+	}
+	if options.Logger == nil {
+		options.Logger = zap.NewNop()
+	}
+
+	c := &Client{
+		options: options,
+		source:  source,
+		client:  &http.Client{Timeout: options.Timeout},
+		logger:  options.Logger.With(zap.String("output", "remote_write")),
+		sent:    make(map[string]time.Time),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName("ghoma", "remote_write", "corrupted_segments_total"),
+			"WAL segments set aside because of a corrupted record",
+			nil, nil,
+		),
+	}
+	if options.WALDir != "" {
+		w, err := newWAL(options.WALDir, options.WALMaxBytes, c.logger)
+		if err != nil {
+			return nil, err
+		}
+		c.wal = w
+	}
+	return c, nil
+}
+
+// Run pushes samples every interval until ctx is done, then pushes the
+// last ones within Timeout.
+func (c *Client) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.options.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Samples that cannot be pushed within Timeout go to the
+			// WAL, shutdown must not wait for their retries.
+			final, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.options.Timeout)
+			c.push(final)
+			cancel()
+			return
+		case <-ticker.C:
+			c.push(ctx)
+		}
+	}
+}
+
+func (c *Client) push(ctx context.Context) {
+	samples := c.pending()
+	if len(samples) == 0 && (c.wal == nil || c.wal.empty()) {
+		return
+	}
+	req := encodeWriteRequest(samples)
+
+	// Buffered requests are older and must be sent first, otherwise their
+	// samples would be rejected as out of order.
+	if c.wal != nil && !c.wal.empty() {
+		if err := c.wal.replay(func(r []byte) error { return c.deliver(ctx, r) }); err != nil {
+			c.buffer(req, len(samples), err)
+			return
+		}
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	if err := c.deliver(ctx, req); err != nil {
+		c.buffer(req, len(samples), err)
+	}
+}
+
+// deliver sends a request, dropping it when the endpoint rejects it since
+// sending it again would be rejected too.
+func (c *Client) deliver(ctx context.Context, req []byte) error {
+	err := c.sendWithRetry(ctx, req)
+	var rejected permanentError
+	if errors.As(err, &rejected) {
+		c.logger.Error("dropping rejected samples", zap.Error(err))
+		return nil
+	}
+	return err
+}
+
+func (c *Client) buffer(req []byte, samples int, err error) {
+	if c.wal == nil {
+		c.logger.Error("dropping samples", zap.Int("samples", samples), zap.Error(err))
+		return
+	}
+	c.logger.Warn("unable to push samples, buffering them on disk", zap.Int("samples", samples), zap.Error(err))
+	if len(req) == 0 {
+		return
+	}
+	if err := c.wal.append(req); err != nil {
+		c.logger.Error("unable to buffer samples", zap.Error(err))
+	}
+}
+
+// pending returns the samples reported since the previous push.
+func (c *Client) pending() []metrics.Sample {
+	var samples []metrics.Sample
+	for _, s := range c.source.Samples() {
+		key := s.Name + "/" + s.Labels["device"] + "/" + s.Labels["outlet"]
+		if !s.Time.After(c.sent[key]) {
+			continue
+		}
+		c.sent[key] = s.Time
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+func (c *Client) sendWithRetry(ctx context.Context, req []byte) error {
+	backoff := c.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, req)
+		var rejected permanentError
+		if err == nil || errors.As(err, &rejected) || attempt >= c.options.MaxRetries {
+			return err
+		}
+		c.logger.Debug("retrying remote write", zap.Int("attempt", attempt+1), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) send(ctx context.Context, req []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.URL, bytes.NewReader(snappy.Encode(nil, req)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", "ghoma-exporter")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case c.options.BearerToken != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.options.BearerToken)
+	case c.options.Username != "":
+		httpReq.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+
+	res, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	switch {
+	case res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("remote write failed with status %d: %s", res.StatusCode, msg)
+	default:
+		return permanentError{status: res.StatusCode, body: string(msg)}
+	}
+}
+
+func (c *Client) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *Client) Collect(ch chan<- prometheus.Metric) {
+	var corrupted float64
+	if c.wal != nil {
+		corrupted = float64(c.wal.corrupted.Load())
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, corrupted)
+}
@@ -0,0 +1,227 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/eliecharra/ghoma/internal/metrics"
+)
+
+type fakeSampler struct {
+	samples []metrics.Sample
+}
+
+func (f *fakeSampler) Samples() []metrics.Sample {
+	return f.samples
+}
+
+// receiverStub stands in for a remote write receiver, recording every
+// series as "name{labels} value@timestamp".
+type receiverStub struct {
+	down   atomic.Bool
+	mu     sync.Mutex
+	series []string
+}
+
+func (s *receiverStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "prom" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	compressed, _ := io.ReadAll(r.Body)
+	req, err := snappy.Decode(nil, compressed)
+	if err != nil || r.Header.Get("Content-Encoding") != "snappy" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ts := range fields(req, 1) {
+		var labels []string
+		for _, l := range fields(ts, 1) {
+			labels = append(labels, string(fields(l, 1)[0])+"="+string(fields(l, 2)[0]))
+		}
+		sample := fields(ts, 2)[0]
+		value, _ := protowire.ConsumeFixed64(sample[1:])
+		timestamp, _ := protowire.ConsumeVarint(sample[10:])
+		sort.Strings(labels)
+		s.series = append(s.series, fmt.Sprintf("%s %v@%d", strings.Join(labels, ","), math.Float64frombits(value), timestamp))
+	}
+}
+
+// fields returns the raw values of the length delimited fields num of msg.
+func fields(msg []byte, num protowire.Number) [][]byte {
+	var res [][]byte
+	for len(msg) > 0 {
+		n, typ, l := protowire.ConsumeTag(msg)
+		msg = msg[l:]
+		l = protowire.ConsumeFieldValue(n, typ, msg)
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(msg)
+			res = append(res, v)
+		}
+		msg = msg[l:]
+	}
+	return res
+}
+
+func TestClient_Push(t *testing.T) {
+	stub := &receiverStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	at := time.UnixMilli(1696111200000)
+	source := &fakeSampler{samples: []metrics.Sample{
+		{Name: "ghoma_energy_power", Labels: map[string]string{"device": "d78a1c"}, Value: 44.04, Time: at},
+	}}
+	c, err := NewClient(source, Options{
+		URL:          srv.URL,
+		Username:     "prom",
+		Password:     "secret",
+		MaxRetries:   1,
+		RetryBackoff: time.Millisecond,
+		WALDir:       t.TempDir(),
+		WALMaxBytes:  1 << 20,
+	})
+	require.NoError(t, err)
+
+	// Endpoint down, the sample is kept in the WAL
+	stub.down.Store(true)
+	c.push(context.Background())
+	require.False(t, c.wal.empty())
+
+	// Unchanged samples are not pushed again
+	stub.down.Store(false)
+	c.wal = nil
+	c.push(context.Background())
+	require.Empty(t, stub.series)
+
+	// Buffered samples are replayed before newer ones
+	c.wal, err = newWAL(c.options.WALDir, c.options.WALMaxBytes, zap.NewNop())
+	require.NoError(t, err)
+	source.samples[0].Value = 45
+	source.samples[0].Time = at.Add(10 * time.Second)
+	c.push(context.Background())
+	require.Equal(t, []string{
+		"__name__=ghoma_energy_power,device=d78a1c 44.04@1696111200000",
+		"__name__=ghoma_energy_power,device=d78a1c 45@1696111210000",
+	}, stub.series)
+	require.True(t, c.wal.empty())
+}
+
+func TestClient_Pending_Outlets(t *testing.T) {
+	at := time.UnixMilli(1696111200000)
+	source := &fakeSampler{samples: []metrics.Sample{
+		{Name: "ghoma_power_watts", Labels: map[string]string{"device": "d78a1c", "outlet": "1"}, Value: 44.04, Time: at},
+		{Name: "ghoma_power_watts", Labels: map[string]string{"device": "d78a1c", "outlet": "2"}, Value: 12, Time: at},
+	}}
+	c, err := NewClient(source, Options{URL: "http://localhost"})
+	require.NoError(t, err)
+
+	require.Len(t, c.pending(), 2, "every outlet is pushed")
+	require.Empty(t, c.pending())
+}
+
+func TestWAL_Trim(t *testing.T) {
+	w, err := newWAL(t.TempDir(), 10, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, w.append([]byte("first")))
+	require.NoError(t, w.rotate())
+	require.NoError(t, w.append([]byte("second")))
+
+	var records []string
+	require.NoError(t, w.replay(func(r []byte) error {
+		records = append(records, string(r))
+		return nil
+	}))
+	require.Equal(t, []string{"second"}, records)
+}
+
+func TestWAL_Corrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(segment []byte)
+	}{
+		{name: "checksum mismatch", corrupt: func(segment []byte) { segment[len(segment)-1] ^= 0xff }},
+		{name: "oversized length", corrupt: func(segment []byte) { segment[len(segment)-9] = 0xff }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := newWAL(t.TempDir(), 1<<20, zap.NewNop())
+			require.NoError(t, err)
+			for _, r := range []string{"a", "b"} {
+				require.NoError(t, w.append([]byte(r)))
+			}
+			require.NoError(t, w.closeSegment())
+			segments, err := w.segments()
+			require.NoError(t, err)
+			segment, err := os.ReadFile(segments[0])
+			require.NoError(t, err)
+			// The second record is 8 bytes of header then "b"
+			tt.corrupt(segment)
+			require.NoError(t, os.WriteFile(segments[0], segment, 0o644))
+
+			var records []string
+			require.NoError(t, w.replay(func(r []byte) error {
+				records = append(records, string(r))
+				return nil
+			}))
+			require.Equal(t, []string{"a"}, records, "records before the corruption are sent")
+			require.True(t, w.empty())
+			require.FileExists(t, segments[0]+corruptExt)
+			require.Equal(t, uint64(1), w.corrupted.Load())
+
+			require.NoError(t, w.append([]byte("c")))
+			records = nil
+			require.NoError(t, w.replay(func(r []byte) error {
+				records = append(records, string(r))
+				return nil
+			}))
+			require.Equal(t, []string{"c"}, records, "the WAL keeps working")
+		})
+	}
+}
+
+func TestWAL_ReplayFailure(t *testing.T) {
+	w, err := newWAL(t.TempDir(), 1<<20, zap.NewNop())
+	require.NoError(t, err)
+	for _, r := range []string{"a", "b", "c"} {
+		require.NoError(t, w.append([]byte(r)))
+	}
+
+	var records []string
+	err = w.replay(func(r []byte) error {
+		if string(r) == "b" && len(records) == 1 {
+			return fmt.Errorf("endpoint down")
+		}
+		records = append(records, string(r))
+		return nil
+	})
+	require.Error(t, err)
+	require.NoError(t, w.replay(func(r []byte) error {
+		records = append(records, string(r))
+		return nil
+	}))
+	require.Equal(t, []string{"a", "b", "c"}, records)
+}
@@ -0,0 +1,254 @@
+package remotewrite
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	walExt         = ".wal"
+	corruptExt     = ".corrupt"
+	maxSegmentSize = 1 << 20
+	// maxRecordSize bounds the length read from a record header, larger
+	// requests are not buffered.
+	maxRecordSize = 16 << 20
+)
+
+var (
+	errRecordTooLarge = errors.New("write request too large to buffer")
+	errCorruptSegment = errors.New("corrupted WAL segment")
+)
+
+// wal buffers encoded write requests while the endpoint is unreachable.
+// Records are appended to segment files as:
+//
+//	uint32 record length, big endian
+//	uint32 CRC-32 (IEEE) of the record, big endian
+//	[]byte record
+//
+// Oldest segments are deleted once the directory grows over maxBytes.
+// Segments holding a corrupted record are renamed with the .corrupt
+// extension once their good records are read, so a single bad record never
+// blocks the WAL.
+type wal struct {
+	dir      string
+	maxBytes int64
+	logger   *zap.Logger
+
+	corrupted atomic.Uint64
+
+	mu      sync.Mutex
+	current *os.File
+	size    int64
+}
+
+func newWAL(dir string, maxBytes int64, logger *zap.Logger) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &wal{dir: dir, maxBytes: maxBytes, logger: logger}, nil
+}
+
+func (w *wal) append(record []byte) error {
+	if len(record) > maxRecordSize {
+		return errRecordTooLarge
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.current == nil || w.size >= maxSegmentSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.current.Write(encodeRecord(record))
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return w.trim()
+}
+
+func (w *wal) rotate() error {
+	if err := w.closeSegment(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(w.dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), walExt)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w.current = f
+	w.size = 0
+	return nil
+}
+
+func (w *wal) closeSegment() error {
+	if w.current == nil {
+		return nil
+	}
+	err := w.current.Close()
+	w.current = nil
+	return err
+}
+
+func (w *wal) trim() error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	sizes := make([]int64, len(segments))
+	for i, s := range segments {
+		info, err := os.Stat(s)
+		if err != nil {
+			return err
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+	// Never delete the last segment, it is the one being written
+	for i := 0; total > w.maxBytes && i < len(segments)-1; i++ {
+		if err := os.Remove(segments[i]); err != nil {
+			return err
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+func (w *wal) empty() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	segments, err := w.segments()
+	return err == nil && len(segments) == 0
+}
+
+// replay sends buffered records oldest first. Sent segments are removed and
+// a segment that fails midway is rewritten with the records left to send.
+func (w *wal) replay(send func([]byte) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.closeSegment(); err != nil {
+		return err
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		records, err := readSegment(segment)
+		quarantined := errors.Is(err, errCorruptSegment)
+		if quarantined {
+			if err := w.quarantine(segment, err); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		for i, record := range records {
+			if err := send(record); err != nil {
+				return errors.Join(err, rewriteSegment(segment, records[i:]))
+			}
+		}
+		if quarantined {
+			continue
+		}
+		if err := os.Remove(segment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantine sets a corrupted segment aside, the records read before the
+// corruption are still sent.
+func (w *wal) quarantine(segment string, cause error) error {
+	w.corrupted.Add(1)
+	w.logger.Error("quarantining WAL segment", zap.String("segment", segment), zap.Error(cause))
+	return os.Rename(segment, segment+corruptExt)
+}
+
+func encodeRecord(record []byte) []byte {
+	buf := make([]byte, 8, 8+len(record))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(record))
+	return append(buf, record...)
+}
+
+func readSegment(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records [][]byte
+	r := bufio.NewReader(f)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			// A truncated trailing record is left by a crash during a write
+			return records, nil
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		if length > maxRecordSize {
+			return records, fmt.Errorf("%w: record of %d bytes in %s", errCorruptSegment, length, path)
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return records, nil
+		}
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(header[4:]) {
+			return records, fmt.Errorf("%w: checksum mismatch in %s", errCorruptSegment, path)
+		}
+		records = append(records, record)
+	}
+}
+
+func rewriteSegment(path string, records [][]byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if _, err := f.Write(encodeRecord(record)); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (w *wal) segments() ([]string, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), walExt) {
+			segments = append(segments, filepath.Join(w.dir, e.Name()))
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+// Snapshot is the in-memory state written on shutdown and restored on the
+// next start.
+type Snapshot struct {
+	Time      time.Time          `json:"time"`
+	Devices   []ghoma.DeviceInfo `json:"devices"`
+	Collector json.RawMessage    `json:"collector,omitempty"`
+	History   json.RawMessage    `json:"history,omitempty"`
+}
+
+// Write replaces the snapshot at path, through a temporary file so a crash
+// while writing never leaves a truncated snapshot behind. Missing parent
+// directories are created.
+func Write(path string, s *Snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Read loads the snapshot at path, the error satisfies os.IsNotExist when
+// there is none.
+func Read(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/eliecharra/ghoma/ghoma"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "ghoma.snapshot")
+
+	_, err := Read(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	s := &Snapshot{
+		Time: time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+		Devices: []ghoma.DeviceInfo{{
+			ID:              "d78a1c",
+			FirmwareVersion: "1.1.6",
+			RemoteAddress:   "192.168.1.10:40000",
+			ConnectedAt:     time.Date(2023, 9, 30, 0, 0, 0, 0, time.UTC),
+		}},
+		Collector: json.RawMessage(`{"devices":{}}`),
+	}
+	require.NoError(t, Write(path, s))
+	require.NoError(t, Write(path, s), "existing snapshot is replaced")
+
+	got, err := Read(path)
+	require.NoError(t, err)
+	require.Equal(t, s, got)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file is left behind")
+}
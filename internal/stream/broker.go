@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+const bufferSize = 64
+
+// Broker fans out server events to subscribers. Publishing never blocks:
+// events are dropped for subscribers whose buffer is full and the drop is
+// reported to them on their next read.
+type Broker struct {
+	logger *zap.Logger
+
+	mu          sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+}
+
+func NewBroker(logger *zap.Logger) *Broker {
+	return &Broker{
+		logger:      logger,
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+type Subscriber struct {
+	filter  Filter
+	events  chan ghoma.Event
+	dropped atomic.Uint64
+}
+
+func (s *Subscriber) Events() <-chan ghoma.Event {
+	return s.events
+}
+
+// Dropped returns and resets the number of events dropped since last call.
+func (s *Subscriber) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+func (b *Broker) Subscribe(filter Filter) *Subscriber {
+	s := &Subscriber{
+		filter: filter,
+		events: make(chan ghoma.Event, bufferSize),
+	}
+	b.mu.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Broker) Unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, s)
+	b.mu.Unlock()
+}
+
+func (b *Broker) HandleEvent(e ghoma.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subscribers {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Filter restricts the events sent to a subscriber. Kinds are matched
+// case-insensitively against the event kind, the message command and the
+// energy kind, so "disconnected", "status" and "power" are all valid.
+type Filter struct {
+	Devices map[string]struct{}
+	Kinds   map[string]struct{}
+}
+
+// NewFilter builds a filter from comma separated lists, empty lists match
+// everything.
+func NewFilter(devices, kinds []string) Filter {
+	f := Filter{}
+	for _, d := range split(devices) {
+		if f.Devices == nil {
+			f.Devices = make(map[string]struct{})
+		}
+		f.Devices[strings.ToLower(d)] = struct{}{}
+	}
+	for _, k := range split(kinds) {
+		if f.Kinds == nil {
+			f.Kinds = make(map[string]struct{})
+		}
+		f.Kinds[strings.ToUpper(k)] = struct{}{}
+	}
+	return f
+}
+
+func (f Filter) Match(e ghoma.Event) bool {
+	if f.Devices != nil {
+		if _, ok := f.Devices[e.Device]; !ok {
+			return false
+		}
+	}
+	if f.Kinds == nil {
+		return true
+	}
+	for _, k := range kinds(e) {
+		if _, ok := f.Kinds[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func kinds(e ghoma.Event) []string {
+	k := []string{strings.ToUpper(string(e.Kind))}
+	if e.Message != nil {
+		k = append(k, e.Message.Command.String())
+		if e.Message.Status != nil && e.Message.Status.Energy != nil {
+			k = append(k, e.Message.Status.Energy.Kind())
+		}
+		if e.Message.Status != nil && e.Message.Status.Switch != nil {
+			k = append(k, "SWITCH")
+		}
+	}
+	return k
+}
+
+func split(values []string) []string {
+	var res []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+type event struct {
+	Kind    string            `json:"kind"`
+	Device  string            `json:"device,omitempty"`
+	Time    time.Time         `json:"time"`
+	Message *protocol.Message `json:"message,omitempty"`
+	Dropped uint64            `json:"dropped,omitempty"`
+	Result  any               `json:"result,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+func newEvent(e ghoma.Event) event {
+	return event{
+		Kind:    string(e.Kind),
+		Device:  e.Device,
+		Time:    e.Time,
+		Message: e.Message,
+	}
+}
+
+func droppedEvent(count uint64) event {
+	return event{
+		Kind:    "dropped",
+		Time:    time.Now(),
+		Dropped: count,
+	}
+}
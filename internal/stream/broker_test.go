@@ -0,0 +1,59 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/ghoma"
+	"github.com/eliecharra/ghoma/protocol"
+)
+
+func TestFilter_Match(t *testing.T) {
+	power := protocol.MustParse([]byte{
+		0x90, 0x01, 0x0a, 0xe0, 0x32, 0x23, 0xd7, 0x8a, 0x1c,
+		0xff, 0xfe, 0x01, 0x81, 0x39, 0x00, 0x00, 0x01,
+		0x01, 0x00, 0x00, 0x12, 0x34,
+	})
+	heartbeat := protocol.MustParse([]byte{0x04})
+
+	tests := []struct {
+		name    string
+		devices []string
+		kinds   []string
+		event   ghoma.Event
+		want    bool
+	}{
+		{name: "no filter", event: ghoma.Event{Kind: ghoma.EventConnected, Device: "d78a1c"}, want: true},
+		{name: "device match", devices: []string{"aaaaaa,d78a1c"}, event: ghoma.Event{Kind: ghoma.EventConnected, Device: "d78a1c"}, want: true},
+		{name: "device mismatch", devices: []string{"aaaaaa"}, event: ghoma.Event{Kind: ghoma.EventConnected, Device: "d78a1c"}, want: false},
+		{name: "event kind", kinds: []string{"disconnected"}, event: ghoma.Event{Kind: ghoma.EventDisconnected, Device: "d78a1c"}, want: true},
+		{name: "command kind", kinds: []string{"status"}, event: ghoma.Event{Kind: ghoma.EventMessage, Message: power}, want: true},
+		{name: "command mismatch", kinds: []string{"status"}, event: ghoma.Event{Kind: ghoma.EventMessage, Message: heartbeat}, want: false},
+		{name: "energy kind", kinds: []string{"power"}, event: ghoma.Event{Kind: ghoma.EventMessage, Message: power}, want: true},
+		{name: "kind mismatch", kinds: []string{"voltage"}, event: ghoma.Event{Kind: ghoma.EventMessage, Message: power}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, NewFilter(tt.devices, tt.kinds).Match(tt.event))
+		})
+	}
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	b := NewBroker(zap.NewNop())
+	sub := b.Subscribe(Filter{})
+
+	for i := 0; i < bufferSize+10; i++ {
+		b.HandleEvent(ghoma.Event{Kind: ghoma.EventConnected})
+	}
+
+	require.Len(t, sub.Events(), bufferSize)
+	require.Equal(t, uint64(10), sub.Dropped())
+	require.Equal(t, uint64(0), sub.Dropped())
+
+	b.Unsubscribe(sub)
+	b.HandleEvent(ghoma.Event{Kind: ghoma.EventConnected})
+	require.Len(t, sub.Events(), bufferSize)
+}
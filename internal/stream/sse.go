@@ -0,0 +1,65 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const keepAliveInterval = 15 * time.Second
+
+// ServeSSE streams events matching the ?device= and ?kind= filters as
+// Server-Sent Events until the client goes away.
+func (b *Broker) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := b.Subscribe(NewFilter(r.URL.Query()["device"], r.URL.Query()["kind"]))
+	defer b.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-sub.Events():
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := writeSSE(w, droppedEvent(dropped)); err != nil {
+					return
+				}
+			}
+			if err := writeSSE(w, newEvent(e)); err != nil {
+				b.logger.Debug("unable to write event", zap.Error(err))
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func writeSSE(w http.ResponseWriter, e event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+	return err
+}
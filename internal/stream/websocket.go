@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"github.com/eliecharra/ghoma/internal/control"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	pingInterval = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+type switcher interface {
+	SwitchDevice(ctx context.Context, id string, on bool) control.Result
+}
+
+// wsCommand is a command sent by a WebSocket client, e.g.
+// {"action": "switch", "device": "d78a1c", "switch": "ON"}.
+type wsCommand struct {
+	Action string `json:"action"`
+	Device string `json:"device"`
+	Switch string `json:"switch"`
+}
+
+// ServeWebSocket streams the same events as ServeSSE over a WebSocket and
+// executes switch commands received from the client, answering each one
+// with a "result" event.
+func (b *Broker) ServeWebSocket(w http.ResponseWriter, r *http.Request, switcher switcher) {
+	filter := NewFilter(r.URL.Query()["device"], r.URL.Query()["kind"])
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		b.logger.Debug("unable to upgrade websocket", zap.Error(err))
+		return
+	}
+	defer conn.Close()
+
+	sub := b.Subscribe(filter)
+	defer b.Unsubscribe(sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replies := make(chan event, bufferSize)
+	reply := func(e event) {
+		select {
+		case replies <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer cancel()
+		for {
+			cmd := wsCommand{}
+			if err := conn.ReadJSON(&cmd); err != nil {
+				if _, ok := err.(*websocket.CloseError); !ok {
+					b.logger.Debug("websocket read error", zap.Error(err))
+				}
+				return
+			}
+			if strings.ToLower(cmd.Action) != "switch" || cmd.Device == "" {
+				reply(event{Kind: "error", Time: time.Now(), Error: "unsupported command"})
+				continue
+			}
+			on := strings.EqualFold(cmd.Switch, "ON")
+			if !on && !strings.EqualFold(cmd.Switch, "OFF") {
+				reply(event{Kind: "error", Device: cmd.Device, Time: time.Now(), Error: "switch must be ON or OFF"})
+				continue
+			}
+			go func() {
+				res := switcher.SwitchDevice(ctx, cmd.Device, on)
+				reply(event{Kind: "result", Device: cmd.Device, Time: time.Now(), Result: res})
+			}()
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		var e event
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+			continue
+		case e = <-replies:
+		case ev := <-sub.Events():
+			if dropped := sub.Dropped(); dropped > 0 {
+				if err := writeWS(conn, droppedEvent(dropped)); err != nil {
+					return
+				}
+			}
+			e = newEvent(ev)
+		}
+		if err := writeWS(conn, e); err != nil {
+			b.logger.Debug("unable to write event", zap.Error(err))
+			return
+		}
+	}
+}
+
+func writeWS(conn *websocket.Conn, e event) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteJSON(e)
+}
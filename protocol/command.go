@@ -9,6 +9,7 @@ const (
 	CmdInit2          Command = 0x05
 	CmdHeartBeatReply Command = 0x06
 	CmdInit2Reply     Command = 0x07
+	CmdSwitch         Command = 0x10
 	CmdStatus         Command = 0x90
 )
 
@@ -26,6 +27,8 @@ func (c Command) String() string {
 		return "HEARTHBEAT_REPLY"
 	case CmdInit2Reply:
 		return "INIT2_REPLY"
+	case CmdSwitch:
+		return "SWITCH"
 	case CmdStatus:
 		return "STATUS"
 	default:
@@ -5,18 +5,126 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 )
 
 type Status struct {
 	Switch *bool
 	Energy *Energy
+	// Outlet is the socket the status is about, from 1. Single outlet plugs
+	// always report outlet 1.
+	Outlet int
+	Type   DeviceType
+	// Source tells what changed the switch, it is only set along Switch.
+	Source SwitchSource
+	// Unknown is set when the status is not one the parser knows, the
+	// fields it decoded anyway are best guesses.
+	Unknown bool
 }
 
+// SwitchSource is what changed the switch state of an outlet.
+type SwitchSource string
+
+const (
+	SourceUnknown SwitchSource = "unknown"
+	// SourceButton is a press on the button of the device, as far as the
+	// source byte goes, see switchSources.
+	SourceButton SwitchSource = "button"
+	// SourceRemote is a switch command sent over the network.
+	SourceRemote SwitchSource = "remote"
+	// SourceTimer is a schedule stored on the device. Plugs report it as a
+	// remote change, see ghoma.Device for how it is told apart.
+	SourceTimer SwitchSource = "timer"
+	// SourcePowerOn is the state the device came up with, it is not sent by
+	// the device either.
+	SourcePowerOn SwitchSource = "power_on"
+)
+
+// switchSources are the source bytes of status payloads.
+//
+// 0x81 is unverified: energy reports carry it at the same offset too, so it
+// may be part of a fixed header rather than a button press. No capture of a
+// press next to a remote switch is available to tell them apart yet.
+var switchSources = map[byte]SwitchSource{
+	0x81: SourceButton, // unverified
+	0x11: SourceRemote,
+}
+
+// DeviceType is the kind of device of the G-Homa family sending a status.
+type DeviceType byte
+
+// DeviceTypePlug is the single outlet metering plug.
+const DeviceTypePlug DeviceType = 0x01
+
+func (t DeviceType) String() string {
+	if t == DeviceTypePlug {
+		return "plug"
+	}
+	return fmt.Sprintf("0x%02x", byte(t))
+}
+
+// Offsets in status payloads, which start with the command, the trigger
+// code and the short MAC address:
+//
+//	90 01 0a e0 | 32 23 | d7 8a 1c | ff fe | 01 | 81 | 39 | 00 00 | 01 | ...
+//	                                 marker  type source report    outlet
+const (
+	statusMarker = 9
+	statusType   = 11
+	statusSource = 12
+	statusReport = 13
+	statusOutlet = 16
+)
+
+// Report bytes of statuses, energy readings carry a value and switch
+// reports carry the switch state in their last byte.
+const (
+	reportSwitch  = 0x11
+	reportMeasure = 0x39
+)
+
+var statusMarkerBytes = []byte{0xff, 0xfe}
+
 type Energy struct {
 	kind  uint8
+	flag  byte
 	value int64
 }
 
+// Measurement is an energy reading converted to its unit. Verified is false
+// when the scale or the sign of the reading is assumed, such values must
+// not be relied upon.
+type Measurement struct {
+	Kind     string  `json:"kind"`
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit"`
+	Verified bool    `json:"verified"`
+}
+
+// scale is the number of raw steps per unit for each energy kind.
+//
+// Only the power scale is verified, it is the divisor the original parser
+// applied to every reading. No capture of the other kinds is available yet,
+// their divisors are guesses from the resolution each unit is usually
+// reported with.
+var scale = map[string]struct {
+	divisor  float64
+	unit     string
+	verified bool
+}{
+	"POWER":     {divisor: 100, unit: "W", verified: true},
+	"MAX_POWER": {divisor: 100, unit: "W"},
+	"ENERGY":    {divisor: 1000, unit: "kWh"},
+	"VOLTAGE":   {divisor: 100, unit: "V"},
+	"CURRENT":   {divisor: 1000, unit: "A"},
+	"FREQUENCY": {divisor: 100, unit: "Hz"},
+	"COSPHI":    {divisor: 10000, unit: ""},
+}
+
+// signFlag is assumed to be set in the byte preceding the value when it is
+// negative, no capture of a negative reading is available yet.
+const signFlag = 0x80
+
 func (e *Energy) Kind() string {
 	switch e.kind {
 	case 1:
@@ -38,10 +146,27 @@ func (e *Energy) Kind() string {
 	}
 }
 
+// Value returns the raw value as sent by the plug, see Measurement for the
+// value in its unit.
 func (e *Energy) Value() int64 {
 	return e.value
 }
 
+// Flag returns the byte preceding the value, its high bit is the sign.
+func (e *Energy) Flag() byte {
+	return e.flag
+}
+
+func (e *Energy) Measurement() Measurement {
+	m := Measurement{Kind: e.Kind(), Value: float64(e.value)}
+	if s, exist := scale[m.Kind]; exist {
+		m.Value /= s.divisor
+		m.Unit = s.unit
+		m.Verified = s.verified && e.flag&signFlag == 0
+	}
+	return m
+}
+
 type Message struct {
 	Payload []byte
 	Command Command
@@ -58,14 +183,26 @@ func Parse(payload []byte) (*Message, error) {
 	}
 
 	if msg.Command == CmdStatus {
-		msg.Status = &Status{}
-		if bytes.Equal(msg.Payload[9:9+len(Measure)], Measure) {
+		msg.Status = &Status{Outlet: 1}
+		layout := len(payload) > statusOutlet && bytes.Equal(payload[statusMarker:statusMarker+2], statusMarkerBytes)
+		if layout {
+			msg.Status.Type = DeviceType(payload[statusType])
+			if outlet := int(payload[statusOutlet]); outlet > 0 {
+				msg.Status.Outlet = outlet
+			}
+		}
+		if layout && payload[statusReport] == reportMeasure && len(payload) >= statusOutlet+6 {
 			msg.Status.Energy = &Energy{}
 
 			msg.Status.Energy.kind = msg.Payload[len(msg.Payload)-5]
+			msg.Status.Energy.flag = msg.Payload[len(msg.Payload)-4]
 			msg.Status.Energy.value = int64(uint32(msg.Payload[len(msg.Payload)-3])<<16 +
 				uint32(msg.Payload[len(msg.Payload)-2])<<8 +
 				uint32(msg.Payload[len(msg.Payload)-1]))
+			if msg.Status.Energy.flag&signFlag != 0 {
+				msg.Status.Energy.value = -msg.Status.Energy.value
+			}
+			msg.Status.Unknown = msg.Status.Energy.Kind() == "UNKNOWN"
 		} else {
 			state := msg.Payload[len(msg.Payload)-1]
 			if state == 0xFF {
@@ -76,7 +213,15 @@ func Parse(payload []byte) (*Message, error) {
 				state := false
 				msg.Status.Switch = &state
 			}
-			//TODO log unknown state reported
+			msg.Status.Unknown = msg.Status.Switch == nil || !layout || payload[statusReport] != reportSwitch
+			if msg.Status.Switch != nil {
+				msg.Status.Source = SourceUnknown
+				if layout {
+					if source, exist := switchSources[payload[statusSource]]; exist {
+						msg.Status.Source = source
+					}
+				}
+			}
 		}
 	}
 
@@ -109,13 +254,19 @@ func (m Message) ToBytes() []byte {
 
 func (m Message) MarshalJSON() ([]byte, error) {
 	type energy struct {
-		Kind  string `json:"kind"`
-		Value int64  `json:"value"`
+		Kind        string  `json:"kind"`
+		Value       int64   `json:"value"`
+		Measurement float64 `json:"measurement"`
+		Unit        string  `json:"unit,omitempty"`
 	}
 
 	type status struct {
-		Switch string  `json:"switch,omitempty"`
-		Energy *energy `json:"energy,omitempty"`
+		Switch  string  `json:"switch,omitempty"`
+		Energy  *energy `json:"energy,omitempty"`
+		Source  string  `json:"source,omitempty"`
+		Outlet  int     `json:"outlet,omitempty"`
+		Type    string  `json:"type,omitempty"`
+		Unknown bool    `json:"unknown,omitempty"`
 	}
 
 	data := &struct {
@@ -131,7 +282,10 @@ func (m Message) MarshalJSON() ([]byte, error) {
 	}
 
 	if m.Status != nil {
-		data.Status = &status{}
+		data.Status = &status{Outlet: m.Status.Outlet, Source: string(m.Status.Source), Unknown: m.Status.Unknown}
+		if m.Status.Type != 0 {
+			data.Status.Type = m.Status.Type.String()
+		}
 		if m.Status.Switch != nil {
 			switch *m.Status.Switch {
 			case true:
@@ -144,6 +298,9 @@ func (m Message) MarshalJSON() ([]byte, error) {
 			e := &energy{}
 			e.Kind = m.Status.Energy.Kind()
 			e.Value = m.Status.Energy.Value()
+			measurement := m.Status.Energy.Measurement()
+			e.Measurement = measurement.Value
+			e.Unit = measurement.Unit
 			data.Status.Energy = e
 		}
 	}
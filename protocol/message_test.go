@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -30,3 +31,167 @@ func TestMessage_ToBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestEnergy_Measurement(t *testing.T) {
+	// Status payloads of a plug measure report, last five bytes are the
+	// kind, the flag and the raw value. None of them is a capture: they
+	// are built from the measure header the original parser matched, with
+	// the kind, flag and value bytes changed. Only the power scale is
+	// known, the other readings must be reported as unverified.
+	tests := []struct {
+		name    string
+		payload string
+		want    Measurement
+	}{
+		{
+			name:    "power",
+			payload: "90010ae03223d78a1cfffe0181390000010100001134",
+			want:    Measurement{Kind: "POWER", Value: 44.04, Unit: "W", Verified: true},
+		},
+		{
+			name:    "energy",
+			payload: "90010ae03223d78a1cfffe018139000001020001e23f",
+			want:    Measurement{Kind: "ENERGY", Value: 123.455, Unit: "kWh"},
+		},
+		{
+			name:    "voltage",
+			payload: "90010ae03223d78a1cfffe018139000001030000598d",
+			want:    Measurement{Kind: "VOLTAGE", Value: 229.25, Unit: "V"},
+		},
+		{
+			name:    "current",
+			payload: "90010ae03223d78a1cfffe01813900000104000000c5",
+			want:    Measurement{Kind: "CURRENT", Value: 0.197, Unit: "A"},
+		},
+		{
+			name:    "frequency",
+			payload: "90010ae03223d78a1cfffe0181390000010500001389",
+			want:    Measurement{Kind: "FREQUENCY", Value: 50.01, Unit: "Hz"},
+		},
+		{
+			name:    "max power",
+			payload: "90010ae03223d78a1cfffe018139000001070001c4ed",
+			want:    Measurement{Kind: "MAX_POWER", Value: 1159.49, Unit: "W"},
+		},
+		{
+			name:    "cos phi",
+			payload: "90010ae03223d78a1cfffe01813900000108000024b8",
+			want:    Measurement{Kind: "COSPHI", Value: 0.94, Unit: ""},
+		},
+		{
+			name:    "negative power",
+			payload: "90010ae03223d78a1cfffe0181390000010180000320",
+			want:    Measurement{Kind: "POWER", Value: -8, Unit: "W"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := hex.DecodeString(tt.payload)
+			require.NoError(t, err)
+			msg, err := Parse(payload)
+			require.NoError(t, err)
+			require.NotNil(t, msg.Status)
+			require.NotNil(t, msg.Status.Energy)
+			got := msg.Status.Energy.Measurement()
+			require.Equal(t, tt.want.Kind, got.Kind)
+			require.Equal(t, tt.want.Unit, got.Unit)
+			require.InDelta(t, tt.want.Value, got.Value, 1e-9)
+			require.Equal(t, tt.want.Verified, got.Verified)
+		})
+	}
+}
+
+func TestParse_Outlet(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name    string
+		status  string
+		outlet  int
+		kind    string
+		on      *bool
+		source  SwitchSource
+		unknown bool
+	}{
+		{
+			name:   "plug switched on",
+			status: "90010ae03223d78a1cfffe011111000001000000ff",
+			outlet: 1,
+			on:     &on,
+			source: SourceRemote,
+		},
+		{
+			name:   "plug switched on by hand",
+			status: "90010ae03223d78a1cfffe018111000001000000ff",
+			outlet: 1,
+			on:     &on,
+			source: SourceButton,
+		},
+		{
+			name:   "strip outlet 3 switched off",
+			status: "90010ae03223d78a1cfffe02111100000300000000",
+			outlet: 3,
+			on:     &off,
+			source: SourceRemote,
+		},
+		{
+			name:   "strip outlet 2 power",
+			status: "90010ae03223d78a1cfffe0281390000020100001134",
+			outlet: 2,
+			kind:   "POWER",
+		},
+		{
+			name:    "short status",
+			status:  "90010ae03223d78a1cff",
+			outlet:  1,
+			on:      &on,
+			source:  SourceUnknown,
+			unknown: true,
+		},
+		{
+			name:    "unknown switch state",
+			status:  "90010ae03223d78a1cfffe01111100000100000042",
+			outlet:  1,
+			unknown: true,
+		},
+		{
+			name:    "unknown report",
+			status:  "90010ae03223d78a1cfffe011122000001000000ff",
+			outlet:  1,
+			on:      &on,
+			source:  SourceRemote,
+			unknown: true,
+		},
+		{
+			name:    "unknown energy kind",
+			status:  "90010ae03223d78a1cfffe0181390000010900001134",
+			outlet:  1,
+			kind:    "UNKNOWN",
+			unknown: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := hex.DecodeString(tt.status)
+			require.NoError(t, err)
+			msg, err := Parse(payload)
+			require.NoError(t, err)
+			require.Equal(t, tt.outlet, msg.Status.Outlet)
+			require.Equal(t, tt.on, msg.Status.Switch)
+			require.Equal(t, tt.source, msg.Status.Source)
+			require.Equal(t, tt.unknown, msg.Status.Unknown)
+			if tt.kind != "" {
+				require.NotNil(t, msg.Status.Energy)
+				require.Equal(t, tt.kind, msg.Status.Energy.Kind())
+			}
+		})
+	}
+}
+
+func TestSwitchOutlet(t *testing.T) {
+	triggerCode, shortMac := []byte{0x32, 0x23}, []byte{0xd7, 0x8a, 0x1c}
+	require.Equal(t, Switch(triggerCode, shortMac, true), SwitchOutlet(triggerCode, shortMac, 1, true))
+	require.Equal(t,
+		"1001010ae03223d78a1cfffe0000101100000300000000",
+		hex.EncodeToString(SwitchOutlet(triggerCode, shortMac, 3, false)),
+	)
+}
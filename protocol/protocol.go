@@ -7,6 +7,37 @@ var Init1ACK = []byte{0x02}
 var Init2 = []byte{0x05, 0x01}
 var Measure = []byte{0xff, 0xfe, 0x01, 0x81, 0x39, 0x00, 0x00, 0x01}
 var HeartBeatReply = []byte{0x06}
+var SwitchHeader = []byte{0x10, 0x01, 0x01, 0x0a, 0xe0}
+var SwitchBody = []byte{0xff, 0xfe, 0x00, 0x00, 0x10, 0x11, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}
+
+// switchOutlet is the offset of the outlet in SwitchBody.
+const switchOutlet = 8
+
+// Switch builds the payload of a switch command for the plug identified by
+// its trigger code and short MAC address, as reported in the INIT1 reply.
+func Switch(triggerCode, shortMac []byte, on bool) []byte {
+	return SwitchOutlet(triggerCode, shortMac, 1, on)
+}
+
+// SwitchOutlet builds the payload of a switch command for a single outlet of
+// a multi-outlet device, outlets are numbered from 1.
+func SwitchOutlet(triggerCode, shortMac []byte, outlet int, on bool) []byte {
+	var state byte = 0x00
+	if on {
+		state = 0xFF
+	}
+
+	var payload []byte
+	payload = append(payload, SwitchHeader...)
+	payload = append(payload, triggerCode...)
+	payload = append(payload, shortMac...)
+	body := len(payload)
+	payload = append(payload, SwitchBody...)
+	payload[body+switchOutlet] = byte(outlet)
+	payload = append(payload, state)
+
+	return payload
+}
 
 func Checksum(payload []byte) byte {
 	var sum byte = 0
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwitch(t *testing.T) {
+	tests := []struct {
+		name string
+		on   bool
+		want []byte
+	}{
+		{
+			name: "switch on",
+			on:   true,
+			want: []byte{
+				0x10, 0x01, 0x01, 0x0a, 0xe0, // Header
+				0x32, 0x23, // Trigger code
+				0xd7, 0x8a, 0x1c, // Short MAC
+				0xff, 0xfe, 0x00, 0x00, 0x10, 0x11, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+				0xff, // State
+			},
+		},
+		{
+			name: "switch off",
+			on:   false,
+			want: []byte{
+				0x10, 0x01, 0x01, 0x0a, 0xe0, // Header
+				0x32, 0x23, // Trigger code
+				0xd7, 0x8a, 0x1c, // Short MAC
+				0xff, 0xfe, 0x00, 0x00, 0x10, 0x11, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+				0x00, // State
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Switch([]byte{0x32, 0x23}, []byte{0xd7, 0x8a, 0x1c}, tt.on))
+		})
+	}
+}
@@ -7,41 +7,63 @@ import (
 	"io"
 )
 
+// ErrInvalidFrame is returned for frames that were read but are malformed.
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// ReadMessage reads a single message. Frames sent back to back end up in the
+// same buffer, so a stream must be read through a *bufio.Reader kept for its
+// whole lifetime, which is used as is.
 func ReadMessage(r io.Reader) (*Message, error) {
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(frame[4 : len(frame)-3])
+}
+
+// ReadFrame reads a single frame, from its prefix to its postfix, without
+// parsing its payload. The bytes read so far are returned with the error
+// when the frame is malformed.
+func ReadFrame(r io.Reader) ([]byte, error) {
 	reader := bufio.NewReader(r)
 
 	// ReadMessage header (prefix + payload length)
-	buffer := make([]byte, 4)
-	if n, err := reader.Read(buffer); n != 4 || err != nil {
-		return nil, errors.Join(errors.New("unable to read message header"), err)
+	frame := make([]byte, 4)
+	if n, err := io.ReadFull(reader, frame); err != nil {
+		return frame[:n], errors.Join(errors.New("unable to read message header"), err)
+	}
+	length := uint16(frame[2])<<8 | uint16(frame[3])
+	if length == 0 {
+		return frame, errors.Join(ErrInvalidFrame, errors.New("empty payload"))
 	}
-	length := uint16(buffer[2])<<8 | uint16(buffer[3])
 
 	// ReadMessage payload based on length declared in header
-	payload := make([]byte, length)
-	if n, err := reader.Read(payload); n != int(length) || err != nil {
-		return nil, errors.Join(errors.New("unable to read payload"), err)
+	frame = append(frame, make([]byte, length)...)
+	if n, err := io.ReadFull(reader, frame[4:]); err != nil {
+		return frame[:4+n], errors.Join(errors.New("unable to read payload"), err)
 	}
+	payload := frame[4:]
 
 	// The next byte should be the checksum byte, check it against the payload
 	checksumByte, err := reader.ReadByte()
 	if err != nil {
-		return nil, errors.Join(errors.New("unable to read checksum byte"), err)
+		return frame, errors.Join(errors.New("unable to read checksum byte"), err)
 	}
+	frame = append(frame, checksumByte)
 	if Checksum(payload) != checksumByte {
-		return nil, errors.New("invalid checksum")
+		return frame, errors.Join(ErrInvalidFrame, errors.New("invalid checksum"))
 	}
 
 	// For consistency, check that the payload ends with the postfix
-	buffer = make([]byte, 2)
-	if n, err := reader.Read(buffer); n != 2 || err != nil || !bytes.Equal(buffer, postfix) {
-		return nil, errors.Join(errors.New("unable to read message postfix"), err)
-	}
-
-	msg, err := Parse(payload)
+	buffer := make([]byte, 2)
+	n, err := io.ReadFull(reader, buffer)
+	frame = append(frame, buffer[:n]...)
 	if err != nil {
-		return msg, err
+		return frame, errors.Join(errors.New("unable to read message postfix"), err)
+	}
+	if !bytes.Equal(buffer, postfix) {
+		return frame, errors.Join(ErrInvalidFrame, errors.New("unable to read message postfix"))
 	}
 
-	return msg, nil
+	return frame, nil
 }
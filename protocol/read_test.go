@@ -1,9 +1,11 @@
 package protocol
 
 import (
+	"bufio"
 	"bytes"
 	"io"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -47,6 +49,11 @@ func TestReadMessage(t *testing.T) {
 			expectedError: "unknown command",
 			want:          &Message{Payload: []byte{0xFF, 0x05, 0x0d, 0x07, 0x05, 0x07, 0x12}, Command: 0xFF},
 		},
+		{
+			name:          "empty payload",
+			reader:        bytes.NewReader([]byte{0x5a, 0xa5, 0x00, 0x00, 0xff, 0x5b, 0xb5}),
+			expectedError: "empty payload",
+		},
 		{
 			name:   "valid message",
 			reader: bytes.NewReader([]byte{0x5a, 0xa5, 0x00, 0x07, 0x02, 0x05, 0x0d, 0x07, 0x05, 0x07, 0x12, 0xc6, 0x5b, 0xb5}),
@@ -65,3 +72,35 @@ func TestReadMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestReadMessage_Stream(t *testing.T) {
+	frames := append(
+		Message{Payload: Init1}.ToBytes(),
+		Message{Payload: HeartBeatReply}.ToBytes()...,
+	)
+	// Delivers a single byte per read, as a slow connection would
+	reader := bufio.NewReader(iotest.OneByteReader(bytes.NewReader(frames)))
+
+	msg, err := ReadMessage(reader)
+	require.NoError(t, err)
+	require.Equal(t, CmdInit1, msg.Command)
+
+	msg, err = ReadMessage(reader)
+	require.NoError(t, err, "second frame must not be lost in the buffer of the first read")
+	require.Equal(t, CmdHeartBeatReply, msg.Command)
+
+	_, err = ReadMessage(reader)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadFrame(t *testing.T) {
+	valid := []byte{0x5a, 0xa5, 0x00, 0x07, 0x02, 0x05, 0x0d, 0x07, 0x05, 0x07, 0x12, 0xc6, 0x5b, 0xb5}
+	frame, err := ReadFrame(bytes.NewReader(valid))
+	require.NoError(t, err)
+	require.Equal(t, valid, frame)
+
+	invalid := []byte{0x5a, 0xa5, 0x00, 0x07, 0x02, 0x05, 0x0d, 0x07, 0x05, 0x08, 0x12, 0xc6, 0x5b, 0xb5}
+	frame, err = ReadFrame(bytes.NewReader(invalid))
+	require.ErrorIs(t, err, ErrInvalidFrame)
+	require.Equal(t, invalid[:12], frame, "bytes read up to the checksum")
+}
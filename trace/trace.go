@@ -0,0 +1,101 @@
+// Package trace records the frames exchanged with plugs, one JSON object per
+// line:
+//
+//	{"time":"2026-10-19T14:20:33.924232572Z","device":"d78a1c","direction":"out","frame":"5aa5000106f95bb5"}
+//
+// direction is "in" for frames read from the plug and "out" for frames
+// written to it. frame is the hex encoding of the whole frame, from its
+// prefix to its postfix, as it went over the wire. Frames that could not be
+// read whole hold the bytes read so far and the read error in "error".
+package trace
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+type Direction string
+
+const (
+	In  Direction = "in"
+	Out Direction = "out"
+)
+
+// Frame is a raw frame, encoded in hex.
+type Frame []byte
+
+func (f Frame) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(f)), nil
+}
+
+func (f *Frame) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*f = b
+	return nil
+}
+
+type Record struct {
+	Time      time.Time `json:"time"`
+	Device    string    `json:"device"`
+	Direction Direction `json:"direction"`
+	Frame     Frame     `json:"frame"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Reader reads the records of a trace.
+type Reader struct {
+	scanner *bufio.Scanner
+}
+
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	return &Reader{scanner: scanner}
+}
+
+// Read returns the next record, io.EOF once every record was read.
+func (r *Reader) Read() (Record, error) {
+	for r.scanner.Scan() {
+		line := r.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record Record
+		err := json.Unmarshal(line, &record)
+		return record, err
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// ReadFile returns every record of a trace file.
+func ReadFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	r := NewReader(f)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
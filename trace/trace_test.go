@@ -0,0 +1,43 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "d78a1c.jsonl")
+	now := time.Date(2026, 10, 19, 14, 20, 33, 0, time.UTC)
+	record := func(i int) Record {
+		return Record{
+			Time:      now.Add(time.Duration(i) * time.Second),
+			Device:    "d78a1c",
+			Direction: Out,
+			Frame:     Frame{0x5a, 0xa5, 0x00, 0x01, 0x06, 0xf9, 0x5b, 0xb5},
+		}
+	}
+
+	f, err := Create(path, 150, 2)
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		require.NoError(t, f.Write(record(i)))
+	}
+	require.NoError(t, f.Close())
+
+	// Records are 95 bytes long, a single one fits in each file
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		records, err := ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, []Record{record(6 - i)}, records)
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	line, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `{"time":"2026-10-19T14:20:39Z","device":"d78a1c","direction":"out","frame":"5aa5000106f95bb5"}`+"\n", string(line))
+}